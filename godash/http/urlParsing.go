@@ -23,9 +23,11 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/url"
 	"path/filepath"
@@ -42,6 +44,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/uccmisl/godash/P2Pconsul"
@@ -59,6 +62,117 @@ func SetNoden(node P2Pconsul.NodeUrl) {
 	Noden = node
 }
 
+// XNCConfig is used to fetch files when quic is used, it is read once by
+// the first quic-xnc request
+var XNCConfig = xnc.DefaultConfig()
+
+// every quic-xnc request of the run shares the session of xncClient
+var xncClient *xnc.Client
+var xncClientOnce sync.Once
+var xncClientErr error
+
+func getXNCClient() (*xnc.Client, error) {
+	xncClientOnce.Do(func() {
+		xncClient, xncClientErr = xnc.NewClient(XNCConfig)
+	})
+	return xncClient, xncClientErr
+}
+
+// XNCTimeout bounds every quic-xnc transfer, XNCRetries is how many more
+// times a failed transfer is tried before falling back to https
+var XNCTimeout = 10 * time.Second
+var XNCRetries = 1
+
+// getXNCFile :
+/*
+ * get the file over quic-xnc, retrying on timeouts and dial failures
+ * only the bytes startRange to endRange (inclusive, as in an http range) are fetched if isByteRange is set
+ * every read is written to createFile as soon as it arrives if saveFilesBool is set
+ * return the content, the rtt and the throughput on the wire
+ */
+func getXNCFile(fileName string, encode bool, isByteRange bool, startRange int, endRange int, createFile string, saveFilesBool bool, debugFile string, debugLog bool) ([]byte, time.Duration, float64, error) {
+
+	var myBytes []byte
+	var rtt time.Duration
+	var kbps float64
+	var err error
+
+	for attempt := 0; attempt <= XNCRetries; attempt++ {
+		myBytes, rtt, kbps, err = getXNCFileOnce(fileName, encode, isByteRange, startRange, endRange, createFile, saveFilesBool, debugFile, debugLog)
+		if err == nil || !(errors.Is(err, xnc.ErrTimeout) || errors.Is(err, xnc.ErrDial)) {
+			break
+		}
+		logging.DebugPrint(debugFile, debugLog, "DEBUG: ", "quic-xnc attempt "+strconv.Itoa(attempt+1)+" for "+fileName+" failed: "+err.Error())
+	}
+
+	return myBytes, rtt, kbps, err
+}
+
+func getXNCFileOnce(fileName string, encode bool, isByteRange bool, startRange int, endRange int, createFile string, saveFilesBool bool, debugFile string, debugLog bool) ([]byte, time.Duration, float64, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), XNCTimeout)
+	defer cancel()
+
+	client, err := getXNCClient()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	// the whole file unless a range is asked for
+	var offset, length int64
+	if isByteRange {
+		offset = int64(startRange)
+		length = int64(endRange - startRange + 1)
+	}
+
+	var out *os.File
+	if saveFilesBool {
+		out, err = os.Create(createFile)
+		if err != nil {
+			fmt.Println("*** " + createFile + " cannot be saved ***")
+			// stop the app
+			utils.StopApp()
+		}
+		defer out.Close()
+	}
+
+	// every read is written to createFile as soon as it arrives, the
+	// client fetches from its mirrors too and resumes the transfer if the
+	// session drops
+	received := 0
+	start := time.Now()
+	myBytes, rtt, kbps, err := client.StreamRange(ctx, fileName, offset, length, encode, func(data []byte) {
+		if out != nil {
+			if _, err := out.Write(data); err != nil {
+				fmt.Println("*** " + createFile + " cannot be saved ***")
+				// stop the app
+				utils.StopApp()
+			}
+		}
+		received += len(data)
+		readKbps := float64(received*8) / glob.Conversion1024 / time.Since(start).Seconds()
+		logging.DebugPrint(debugFile, debugLog, "DEBUG: ", fmt.Sprintf("quic-xnc received %d bytes of %s at %.2f kbps", received, fileName, readKbps))
+	})
+	if err != nil {
+		// don't leave half a segment behind for the https fallback
+		if out != nil {
+			out.Close()
+			os.Remove(createFile)
+		}
+		return nil, rtt, 0, err
+	}
+
+	transfers := client.Transfers()
+	for i := len(transfers) - 1; i >= 0; i-- {
+		if stats := transfers[i]; stats.Filename == fileName {
+			logging.DebugPrint(debugFile, debugLog, "DEBUG: ", fmt.Sprintf("quic-xnc %s: %d pieces, %d innovative, %d linearly dependent, redundancy %.3f, goodput %.2f kbps of %.2f kbps", fileName, stats.Pieces, stats.Innovative, stats.Dependent, stats.Redundancy(), stats.Goodput(), stats.Throughput()))
+			break
+		}
+	}
+
+	return myBytes, rtt, kbps, nil
+}
+
 // getHTTPClient:
 func GetHTTPClient(quicBool bool, debugFile string, debugLog bool, useTestbedBool bool) (*http.Transport, *http.Client, *h2quic.RoundTripper) {
 
@@ -477,27 +591,30 @@ func GetURL(requrl string, isByteRangeMPD bool, startRange int, endRange int, qu
 		// Use the path package to extract the file name.
 		fileName := path.Base(u.Path)
 
-		bytes, rtt, _ := xnc.Client(fileName, false)
-
-		return bytes, rtt, "quic-xnc"
-	} else {
-		responseBody, rtt, protocol, _ := getURLBody(requrl, isByteRangeMPD, startRange, endRange, quicBool, debugFile, debugLog, useTestbedBool, false)
-
-		// Lets read from the http stream and not create a file to store the body
-		body, err := ioutil.ReadAll(responseBody)
-		//bodyString := string(body)
-		if err != nil {
-			fmt.Println("Unable to read from url")
-			// stop the app
-			utils.StopApp()
+		bytes, rtt, _, err := getXNCFile(fileName, false, isByteRangeMPD, startRange, endRange, "", false, debugFile, debugLog)
+		if err == nil {
+			return bytes, rtt, "quic-xnc"
 		}
+		fmt.Println("*** quic-xnc failed, falling back to https: " + err.Error() + " ***")
+		quicBool = false
+	}
 
-		// close the responseBody
-		responseBody.Close()
+	responseBody, rtt, protocol, _ := getURLBody(requrl, isByteRangeMPD, startRange, endRange, quicBool, debugFile, debugLog, useTestbedBool, false)
 
-		// return the body of the responseBody
-		return body, rtt, protocol
+	// Lets read from the http stream and not create a file to store the body
+	body, err := ioutil.ReadAll(responseBody)
+	//bodyString := string(body)
+	if err != nil {
+		fmt.Println("Unable to read from url")
+		// stop the app
+		utils.StopApp()
 	}
+
+	// close the responseBody
+	responseBody.Close()
+
+	// return the body of the responseBody
+	return body, rtt, protocol
 }
 
 // GetRepresentationBaseURL :
@@ -579,9 +696,16 @@ func GetFile(currentURL string, fileBaseURL string, fileLocation string, isByteR
 
 	//request the URL with GET
 	if quicBool {
-		myBytes, rtt, kbpsFloat = xnc.Client(fileBaseURL, true)
-		protocol = "quic-xnc"
-	} else {
+		var err error
+		myBytes, rtt, kbpsFloat, err = getXNCFile(fileBaseURL, true, isByteRangeMPD, startRange, endRange, createFile, saveFilesBool, debugFile, debugLog)
+		if err == nil {
+			protocol = "quic-xnc"
+		} else {
+			fmt.Println("*** quic-xnc failed, falling back to https: " + err.Error() + " ***")
+			quicBool = false
+		}
+	}
+	if !quicBool {
 		body, rtt, protocol, _ = getURLBody(urlHeaderString, isByteRangeMPD, startRange, endRange, quicBool, debugFile, debugLog, useTestbedBool, false)
 		// read from the buffer
 		var buf bytes.Buffer
@@ -631,15 +755,8 @@ func GetFile(currentURL string, fileBaseURL string, fileLocation string, isByteR
 
 	// if we want to save the streamed files
 	if saveFilesBool {
-		if quicBool {
-			err = os.WriteFile(createFile, myBytes, 0644)
-			if err != nil {
-				fmt.Println("*** " + createFile + " cannot be saved ***")
-				// stop the app
-				utils.StopApp()
-			}
-		} else {
-
+		// a quic-xnc segment was written to createFile while it was being received
+		if !quicBool {
 			// Restore the io.ReadCloser to it's original state, if needed
 			body = ioutil.NopCloser(bytes.NewBuffer(myBytes))
 
@@ -14,28 +14,25 @@ type DecoderState struct {
 	coded      Matrix
 }
 
-func min(a, b int) int {
-	if a <= b {
-		return a
-	}
-	return b
-}
-
+// Brings coefficient matrix into row echelon form, searching
+// for pivot of each row column by column, so that rows which
+// don't touch leading columns ( say, when only a window of
+// pieces are coded together ) are still eliminated correctly
 func (d *DecoderState) clean_forward() {
 	var (
-		rows     int = int(d.coeffs.Rows())
-		cols     int = int(d.coeffs.Cols())
-		boundary int = min(rows, cols)
+		rows int = int(d.coeffs.Rows())
+		cols int = int(d.coeffs.Cols())
 	)
 
 	var wg sync.WaitGroup
 
-	for i := 0; i < boundary; i++ {
-		if d.coeffs[i][i] == 0 {
+	i := 0
+	for col := 0; col < cols && i < rows; col++ {
+		if d.coeffs[i][col] == 0 {
 			non_zero_col := false
 			pivot := i + 1
 			for ; pivot < rows; pivot++ {
-				if d.coeffs[pivot][i] != 0 {
+				if d.coeffs[pivot][col] != 0 {
 					non_zero_col = true
 					break
 				}
@@ -56,52 +53,60 @@ func (d *DecoderState) clean_forward() {
 		}
 
 		for j := i + 1; j < rows; j++ {
-			if d.coeffs[j][i] == 0 {
+			if d.coeffs[j][col] == 0 {
 				continue
 			}
 
 			wg.Add(1)
-			go func(j int) {
+			go func(i, j, col int) {
 				defer wg.Done()
-				quotient := d.field.Div(d.coeffs[j][i], d.coeffs[i][i])
-				for k := i; k < cols; k++ {
+				quotient := d.field.Div(d.coeffs[j][col], d.coeffs[i][col])
+				for k := col; k < cols; k++ {
 					d.coeffs[j][k] = d.field.Add(d.coeffs[j][k], d.field.Mul(d.coeffs[i][k], quotient))
 				}
 				for k := 0; k < len(d.coded[0]); k++ {
 					d.coded[j][k] = d.field.Add(d.coded[j][k], d.field.Mul(d.coded[i][k], quotient))
 				}
-			}(j)
+			}(i, j, col)
 		}
 
 		wg.Wait() // Wait for all goroutines to finish before moving to the next pivot
+		i++
 	}
 }
 
+// Expects coefficient matrix to be in row echelon form, zeroes
+// cells above each row's pivot & scales pivot to 1
 func (d *DecoderState) clean_backward() {
 	var (
-		rows     int = int(d.coeffs.Rows())
-		cols     int = int(d.coeffs.Cols())
-		boundary int = min(rows, cols)
+		rows int = int(d.coeffs.Rows())
+		cols int = int(d.coeffs.Cols())
 	)
 
 	var wg sync.WaitGroup
 
-	for i := boundary - 1; i >= 0; i-- {
-		if d.coeffs[i][i] == 0 {
+	for i := rows - 1; i >= 0; i-- {
+		col := 0
+		for ; col < cols; col++ {
+			if d.coeffs[i][col] != 0 {
+				break
+			}
+		}
+		if col == cols {
 			continue
 		}
 
 		// Parallelizing row operations above the current pivot
 		for j := 0; j < i; j++ {
-			if d.coeffs[j][i] == 0 {
+			if d.coeffs[j][col] == 0 {
 				continue
 			}
 
 			wg.Add(1)
 			go func(j int) {
 				defer wg.Done()
-				quotient := d.field.Div(d.coeffs[j][i], d.coeffs[i][i])
-				for k := i; k < cols; k++ {
+				quotient := d.field.Div(d.coeffs[j][col], d.coeffs[i][col])
+				for k := col; k < cols; k++ {
 					d.coeffs[j][k] = d.field.Add(d.coeffs[j][k], d.field.Mul(d.coeffs[i][k], quotient))
 				}
 				for k := 0; k < len(d.coded[0]); k++ {
@@ -111,16 +116,16 @@ func (d *DecoderState) clean_backward() {
 		}
 		wg.Wait()
 
-		if d.coeffs[i][i] == 1 {
+		if d.coeffs[i][col] == 1 {
 			continue
 		}
 
-		inv := d.field.Div(1, d.coeffs[i][i])
-		d.coeffs[i][i] = 1
+		inv := d.field.Div(1, d.coeffs[i][col])
+		d.coeffs[i][col] = 1
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for j := i + 1; j < cols; j++ {
+			for j := col + 1; j < cols; j++ {
 				if d.coeffs[i][j] == 0 {
 					continue
 				}
@@ -91,6 +91,34 @@ func TestMatrixRank(t *testing.T) {
 	}
 }
 
+func TestMatrixRankSparse(t *testing.T) {
+	field := galoisfield.DefaultGF256
+
+	{
+		m := matrix.Matrix{{0, 0, 1, 0}, {0, 0, 1, 0}}
+		coded := matrix.Matrix{{0, 0, 0, 0}, {0, 0, 0, 0}}
+
+		dec := matrix.NewDecoderState(field, m, coded)
+		dec.Rref()
+		if rank := dec.Rank(); rank != 1 {
+			t.Fatalf("expected rank 1, received %d", rank)
+		}
+	}
+
+	{
+		m := matrix.Matrix{{0, 3, 7, 0}, {0, 0, 5, 9}, {0, 6, 14, 0}, {0, 1, 0, 0}}
+		m_rref := matrix.Matrix{{0, 1, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}
+		coded := matrix.Matrix{{0, 0, 0, 0}, {0, 0, 0, 0}, {0, 0, 0, 0}, {0, 0, 0, 0}}
+
+		dec := matrix.NewDecoderState(field, m, coded)
+		dec.Rref()
+		res := dec.CoefficientMatrix()
+		if !res.Cmp(m_rref) {
+			t.Fatalf("rref doesn't match, received %v", res)
+		}
+	}
+}
+
 func TestMatrixMultiplication(t *testing.T) {
 	field := galoisfield.DefaultGF256
 
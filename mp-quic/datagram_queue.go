@@ -0,0 +1,162 @@
+package quic
+
+import (
+	"sync"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/wire"
+)
+
+// datagramQueue holds DATAGRAM frames waiting to be packed, and received
+// datagrams waiting to be read by the application
+type datagramQueue struct {
+	sendQueue chan *wire.DatagramFrame
+	rcvQueue  chan []byte
+
+	// frames which may only be packed for one path
+	pathQueuesLock sync.Mutex
+	pathQueues     map[protocol.PathID]chan *wire.DatagramFrame
+
+	// a frame that was dequeued but didn't fit into the last packet
+	// only accessed by the packer
+	pending     *wire.DatagramFrame
+	pathPending map[protocol.PathID]*wire.DatagramFrame
+
+	closeErr  error
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	hasData func()
+}
+
+func newDatagramQueue(hasData func()) *datagramQueue {
+	return &datagramQueue{
+		sendQueue:   make(chan *wire.DatagramFrame, protocol.MaxDatagramQueueLen),
+		rcvQueue:    make(chan []byte, protocol.MaxDatagramQueueLen),
+		pathQueues:  make(map[protocol.PathID]chan *wire.DatagramFrame),
+		pathPending: make(map[protocol.PathID]*wire.DatagramFrame),
+		closed:      make(chan struct{}),
+		hasData:     hasData,
+	}
+}
+
+func (q *datagramQueue) pathQueue(pathID protocol.PathID) chan *wire.DatagramFrame {
+	q.pathQueuesLock.Lock()
+	defer q.pathQueuesLock.Unlock()
+
+	queue, ok := q.pathQueues[pathID]
+	if !ok {
+		queue = make(chan *wire.DatagramFrame, protocol.MaxDatagramQueueLen)
+		q.pathQueues[pathID] = queue
+	}
+	return queue
+}
+
+// AddOnPathAndWait queues a DATAGRAM frame which is only sent on the given path,
+// blocking while the queue of that path is full
+func (q *datagramQueue) AddOnPathAndWait(f *wire.DatagramFrame, pathID protocol.PathID) error {
+	select {
+	case q.pathQueue(pathID) <- f:
+		q.hasData()
+		return nil
+	case <-q.closed:
+		return q.closeErr
+	}
+}
+
+// HasDataForPath returns true if frames are waiting to be sent on the given path
+func (q *datagramQueue) HasDataForPath(pathID protocol.PathID) bool {
+	return q.pathPending[pathID] != nil || len(q.pathQueue(pathID)) > 0
+}
+
+// MovePathData requeues the frames waiting for the given path, so they are sent on any path
+func (q *datagramQueue) MovePathData(pathID protocol.PathID) {
+	frames := make([]*wire.DatagramFrame, 0)
+	if f := q.pathPending[pathID]; f != nil {
+		frames = append(frames, f)
+		delete(q.pathPending, pathID)
+	}
+	queue := q.pathQueue(pathID)
+	for len(queue) > 0 {
+		frames = append(frames, <-queue)
+	}
+
+	for _, f := range frames {
+		select {
+		case q.sendQueue <- f:
+		default:
+			// the shared queue is full, the datagram is lost like one dropped on the wire
+		}
+	}
+}
+
+// AddAndWait queues a DATAGRAM frame for sending, blocking while the queue is full
+func (q *datagramQueue) AddAndWait(f *wire.DatagramFrame) error {
+	select {
+	case q.sendQueue <- f:
+		q.hasData()
+		return nil
+	case <-q.closed:
+		return q.closeErr
+	}
+}
+
+// Pop returns the next DATAGRAM frame for a packet on the given path if it fits
+// into maxLen, nil otherwise. Frames queued for that path come first.
+func (q *datagramQueue) Pop(maxLen protocol.ByteCount, pathID protocol.PathID) *wire.DatagramFrame {
+	if q.pathPending[pathID] == nil {
+		select {
+		case q.pathPending[pathID] = <-q.pathQueue(pathID):
+		default:
+		}
+	}
+	if f := q.pathPending[pathID]; f != nil {
+		if l, _ := f.MinLength(0); l > maxLen {
+			return nil
+		}
+		delete(q.pathPending, pathID)
+		return f
+	}
+
+	if q.pending == nil {
+		select {
+		case q.pending = <-q.sendQueue:
+		default:
+			return nil
+		}
+	}
+
+	if l, _ := q.pending.MinLength(0); l > maxLen {
+		return nil
+	}
+
+	f := q.pending
+	q.pending = nil
+	return f
+}
+
+// HandleDatagramFrame passes a received datagram to the application,
+// dropping it if the application doesn't keep up
+func (q *datagramQueue) HandleDatagramFrame(f *wire.DatagramFrame) {
+	select {
+	case q.rcvQueue <- f.Data:
+	default:
+	}
+}
+
+// Receive blocks until a datagram is received or the session is closed
+func (q *datagramQueue) Receive() ([]byte, error) {
+	select {
+	case data := <-q.rcvQueue:
+		return data, nil
+	case <-q.closed:
+		return nil, q.closeErr
+	}
+}
+
+func (q *datagramQueue) CloseWithError(e error) {
+	q.closeOnce.Do(func() {
+		q.closeErr = e
+		close(q.closed)
+	})
+}
@@ -0,0 +1,44 @@
+package quic
+
+import (
+	"github.com/lucas-clemente/quic-go/internal/wire"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Datagram Queue", func() {
+	var queue *datagramQueue
+
+	BeforeEach(func() {
+		queue = newDatagramQueue(func() {})
+	})
+
+	It("packs frames steered to a path only on that path, before the others", func() {
+		Expect(queue.AddAndWait(&wire.DatagramFrame{Data: []byte("any")})).To(Succeed())
+		Expect(queue.AddOnPathAndWait(&wire.DatagramFrame{Data: []byte("path 2")}, 2)).To(Succeed())
+		Expect(queue.HasDataForPath(1)).To(BeFalse())
+		Expect(queue.HasDataForPath(2)).To(BeTrue())
+
+		Expect(queue.Pop(1000, 1).Data).To(Equal([]byte("any")))
+		Expect(queue.Pop(1000, 1)).To(BeNil())
+		Expect(queue.Pop(1000, 2).Data).To(Equal([]byte("path 2")))
+		Expect(queue.HasDataForPath(2)).To(BeFalse())
+	})
+
+	It("keeps a steered frame which doesn't fit for the next packet on its path", func() {
+		Expect(queue.AddOnPathAndWait(&wire.DatagramFrame{Data: make([]byte, 100)}, 2)).To(Succeed())
+		Expect(queue.Pop(50, 2)).To(BeNil())
+		Expect(queue.HasDataForPath(2)).To(BeTrue())
+		Expect(queue.Pop(1000, 2).Data).To(HaveLen(100))
+	})
+
+	It("moves the frames of a path to the shared queue", func() {
+		Expect(queue.AddOnPathAndWait(&wire.DatagramFrame{Data: []byte("a")}, 2)).To(Succeed())
+		Expect(queue.AddOnPathAndWait(&wire.DatagramFrame{Data: []byte("b")}, 2)).To(Succeed())
+		queue.MovePathData(2)
+		Expect(queue.HasDataForPath(2)).To(BeFalse())
+		Expect(queue.Pop(1000, 1).Data).To(Equal([]byte("a")))
+		Expect(queue.Pop(1000, 3).Data).To(Equal([]byte("b")))
+	})
+})
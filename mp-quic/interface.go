@@ -13,9 +13,28 @@ import (
 // The StreamID is the ID of a QUIC stream.
 type StreamID = protocol.StreamID
 
+// A PathID identifies one path of a multipath session.
+type PathID = protocol.PathID
+
+// PathStats describes one path of a session as seen by its sender.
+type PathStats struct {
+	PathID PathID
+	// SmoothedRTT is zero until the path carried its first RTT sample.
+	SmoothedRTT       time.Duration
+	CongestionWindow  uint64
+	BytesInFlight     uint64
+	PacketsSent       uint64
+	PacketsRetrans    uint64
+	PacketsLost       uint64
+	PotentiallyFailed bool
+}
+
 // A VersionNumber is a QUIC version number.
 type VersionNumber = protocol.VersionNumber
 
+// MaxDatagramSize is the largest payload accepted by Session.SendDatagram.
+const MaxDatagramSize = int(protocol.MaxDatagramSize)
+
 // A Cookie can be used to verify the ownership of the client address.
 type Cookie = handshake.Cookie
 
@@ -71,6 +90,18 @@ type Session interface {
 	OpenStreamSync() (Stream, error)
 
 	GetRtt() time.Duration
+	// SendDatagram sends an unreliable datagram of at most MaxDatagramSize bytes.
+	// It is acknowledged and congestion controlled, but never retransmitted.
+	// Both peers need to support DATAGRAM frames.
+	SendDatagram([]byte) error
+	// SendDatagramOnPath is SendDatagram on the given path. The datagram goes out
+	// on any path if that path doesn't exist or is potentially failed.
+	SendDatagramOnPath([]byte, PathID) error
+	// PathStats returns the state of every path, the initial path first.
+	PathStats() []PathStats
+	// ReceiveDatagram blocks until the next datagram sent by the peer is available.
+	// Datagrams are dropped if they are not read fast enough.
+	ReceiveDatagram() ([]byte, error)
 	// LocalAddr returns the local address.
 	LocalAddr() net.Addr
 	// RemoteAddr returns the address of the peer.
@@ -98,6 +98,12 @@ const MaxPacketsReceivedBeforeAckSend = 20
 // MaxNonRetransmittablePackets is the maximum number of non-retransmittable packets that we send in a row
 const MaxNonRetransmittablePackets = 19
 
+// MaxDatagramSize is the maximum payload of a DATAGRAM frame, small enough to fit into one packet next to ACK and STOP_WAITING frames
+const MaxDatagramSize ByteCount = 1200
+
+// MaxDatagramQueueLen is the number of DATAGRAM frames that are queued for sending or reading before new ones are blocked or dropped
+const MaxDatagramQueueLen = 256
+
 // RetransmittablePacketsBeforeAck is the number of retransmittable that an ACK is sent for
 const RetransmittablePacketsBeforeAck = 2
 
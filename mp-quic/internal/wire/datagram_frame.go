@@ -0,0 +1,58 @@
+package wire
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/utils"
+)
+
+// ErrDatagramTooLarge is returned when a DATAGRAM frame doesn't fit into a single packet
+var ErrDatagramTooLarge = errors.New("DatagramFrame: data too large")
+
+// A DatagramFrame carries application data that is never retransmitted
+type DatagramFrame struct {
+	Data []byte
+}
+
+// ParseDatagramFrame parses a DATAGRAM frame
+func ParseDatagramFrame(r *bytes.Reader, version protocol.VersionNumber) (*DatagramFrame, error) {
+	frame := &DatagramFrame{}
+
+	// read the TypeByte
+	if _, err := r.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	length, err := utils.GetByteOrder(version).ReadUint16(r)
+	if err != nil {
+		return nil, err
+	}
+	if int(length) > r.Len() {
+		return nil, ErrDatagramTooLarge
+	}
+
+	frame.Data = make([]byte, length)
+	if _, err := r.Read(frame.Data); err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+func (f *DatagramFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
+	if len(f.Data) > int(protocol.MaxDatagramSize) {
+		return ErrDatagramTooLarge
+	}
+
+	b.WriteByte(0x13)
+	utils.GetByteOrder(version).WriteUint16(b, uint16(len(f.Data)))
+	b.Write(f.Data)
+	return nil
+}
+
+// MinLength of a written frame
+func (f *DatagramFrame) MinLength(version protocol.VersionNumber) (protocol.ByteCount, error) {
+	return 1 + 2 + protocol.ByteCount(len(f.Data)), nil
+}
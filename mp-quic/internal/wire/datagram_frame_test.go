@@ -0,0 +1,58 @@
+package wire
+
+import (
+	"bytes"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DatagramFrame", func() {
+	Context("when parsing", func() {
+		It("accepts sample frame", func() {
+			b := bytes.NewReader([]byte{0x13, 0x0, 0x3, 0xde, 0xad, 0xbe})
+			frame, err := ParseDatagramFrame(b, versionBigEndian)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame.Data).To(Equal([]byte{0xde, 0xad, 0xbe}))
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("errors when the length exceeds the packet", func() {
+			b := bytes.NewReader([]byte{0x13, 0x0, 0x8, 0xde, 0xad})
+			_, err := ParseDatagramFrame(b, versionBigEndian)
+			Expect(err).To(MatchError(ErrDatagramTooLarge))
+		})
+
+		It("errors on EOFs", func() {
+			data := []byte{0x13, 0x0, 0x2, 0xca, 0xfe}
+			_, err := ParseDatagramFrame(bytes.NewReader(data), versionBigEndian)
+			Expect(err).NotTo(HaveOccurred())
+			for i := range data[:3] {
+				_, err := ParseDatagramFrame(bytes.NewReader(data[0:i]), versionBigEndian)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	Context("when writing", func() {
+		It("writes a sample frame", func() {
+			b := &bytes.Buffer{}
+			frame := DatagramFrame{Data: []byte{0xca, 0xfe}}
+			err := frame.Write(b, versionBigEndian)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b.Bytes()).To(Equal([]byte{0x13, 0x0, 0x2, 0xca, 0xfe}))
+		})
+
+		It("refuses data larger than a packet", func() {
+			b := &bytes.Buffer{}
+			frame := DatagramFrame{Data: make([]byte, protocol.MaxDatagramSize+1)}
+			Expect(frame.Write(b, versionBigEndian)).To(MatchError(ErrDatagramTooLarge))
+		})
+
+		It("has the correct min length", func() {
+			frame := DatagramFrame{Data: []byte{0xca, 0xfe}}
+			Expect(frame.MinLength(0)).To(Equal(protocol.ByteCount(5)))
+		})
+	})
+})
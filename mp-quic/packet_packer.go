@@ -26,6 +26,7 @@ type packetPacker struct {
 
 	connectionParameters  handshake.ConnectionParametersManager
 	streamFramer          *streamFramer
+	datagramQueue         *datagramQueue
 
 	controlFrames []wire.Frame
 	stopWaiting   map[protocol.PathID]*wire.StopWaitingFrame
@@ -149,7 +150,15 @@ func (p *packetPacker) PackPacket(pth *path) (*packedPacket, error) {
 
 	var payloadFrames []wire.Frame
 	if isPing {
-		payloadFrames = []wire.Frame{p.controlFrames[0]}
+		// STOP_WAITING and ACK always fit, send them along with the ping
+		// so that a pending STOP_WAITING never holds the ping back
+		if p.stopWaiting[pth.pathID] != nil {
+			payloadFrames = append(payloadFrames, p.stopWaiting[pth.pathID])
+		}
+		if p.ackFrame[pth.pathID] != nil {
+			payloadFrames = append(payloadFrames, p.ackFrame[pth.pathID])
+		}
+		payloadFrames = append(payloadFrames, p.controlFrames[0])
 		// Remove the ping frame from the control frames
 		p.controlFrames = p.controlFrames[1:len(p.controlFrames)]
 	} else {
@@ -252,6 +261,14 @@ func (p *packetPacker) composeNextPacket(
 		return payloadFrames, nil
 	}
 
+	if p.datagramQueue != nil {
+		for f := p.datagramQueue.Pop(maxFrameSize-payloadLength, pth.pathID); f != nil; f = p.datagramQueue.Pop(maxFrameSize-payloadLength, pth.pathID) {
+			l, _ := f.MinLength(p.version)
+			payloadFrames = append(payloadFrames, f)
+			payloadLength += l
+		}
+	}
+
 	// temporarily increase the maxFrameSize by 2 bytes
 	// this leads to a properly sized packet in all cases, since we do all the packet length calculations with StreamFrames that have the DataLen set
 	// however, for the last StreamFrame in the packet, we can omit the DataLen, thus saving 2 bytes and yielding a packet of exactly the correct size
@@ -608,6 +608,20 @@ var _ = Describe("Packet packer", func() {
 		Expect(p).To(BeNil())
 	})
 
+	It("packs a PING along with a queued STOP_WAITING and ACK", func() {
+		pth.packetNumberGenerator.next = 15
+		swf := &wire.StopWaitingFrame{LeastUnacked: 10}
+		ack := &wire.AckFrame{LargestAcked: 42}
+		packer.QueueControlFrame(swf, pth)
+		packer.QueueControlFrame(ack, pth)
+		packer.QueueControlFrame(&wire.PingFrame{}, pth)
+		p, err := packer.PackPacket(pth)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p).ToNot(BeNil())
+		Expect(p.frames).To(Equal([]wire.Frame{swf, ack, &wire.PingFrame{}}))
+		Expect(packer.controlFrames).To(BeEmpty())
+	})
+
 	It("packs a single ACK", func() {
 		ack := &wire.AckFrame{LargestAcked: 42}
 		packer.QueueControlFrame(ack, pth)
@@ -62,6 +62,8 @@ func (sch *scheduler) getRetransmission(s *session) (hasRetransmission bool, ret
 			case *wire.PathsFrame:
 				// Schedule a new PATHS frame to send
 				s.schedulePathsFrame()
+			case *wire.DatagramFrame:
+				// Datagrams are unreliable, the application recovers losses itself
 			default:
 				s.packer.QueueControlFrame(frame, pth)
 			}
@@ -204,8 +206,36 @@ pathLoop:
 	return selectedPath
 }
 
+// selectPathSteered returns a path which has datagrams waiting for it and can
+// send them. Datagrams waiting for a path which failed go to any path instead.
+func (sch *scheduler) selectPathSteered(s *session, hasRetransmission bool) *path {
+	for pathID, pth := range s.paths {
+		if !s.datagramQueue.HasDataForPath(pathID) {
+			continue
+		}
+
+		if pth.potentiallyFailed.Get() || !pth.open.Get() {
+			s.datagramQueue.MovePathData(pathID)
+			continue
+		}
+
+		if !hasRetransmission && !pth.SendingAllowed() {
+			continue
+		}
+
+		return pth
+	}
+
+	return nil
+}
+
 // Lock of s.paths must be held
 func (sch *scheduler) selectPath(s *session, hasRetransmission bool, hasStreamRetransmission bool, fromPth *path) *path {
+	// Datagrams steered by the application come first
+	if pth := sch.selectPathSteered(s, hasRetransmission); pth != nil {
+		return pth
+	}
+
 	// XXX Currently round-robin
 	// TODO select the right scheduler dynamically
 	return sch.selectPathLowLatency(s, hasRetransmission, hasStreamRetransmission, fromPth)
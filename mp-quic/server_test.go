@@ -61,13 +61,17 @@ func (s *mockSession) closeRemote(e error) {
 func (s *mockSession) OpenStream() (Stream, error) {
 	return &stream{streamID: 1337}, nil
 }
-func (s *mockSession) AcceptStream() (Stream, error)    { panic("not implemented") }
-func (s *mockSession) OpenStreamSync() (Stream, error)  { panic("not implemented") }
-func (s *mockSession) GetRtt() time.Duration            { panic("not implemented") }
-func (s *mockSession) LocalAddr() net.Addr              { panic("not implemented") }
-func (s *mockSession) RemoteAddr() net.Addr             { return s.remoteAddr }
-func (*mockSession) Context() context.Context           { panic("not implemented") }
-func (*mockSession) GetVersion() protocol.VersionNumber { return protocol.VersionWhatever }
+func (s *mockSession) AcceptStream() (Stream, error)           { panic("not implemented") }
+func (s *mockSession) OpenStreamSync() (Stream, error)         { panic("not implemented") }
+func (s *mockSession) GetRtt() time.Duration                   { panic("not implemented") }
+func (s *mockSession) SendDatagram([]byte) error               { panic("not implemented") }
+func (s *mockSession) ReceiveDatagram() ([]byte, error)        { panic("not implemented") }
+func (s *mockSession) SendDatagramOnPath([]byte, PathID) error { panic("not implemented") }
+func (s *mockSession) PathStats() []PathStats                  { panic("not implemented") }
+func (s *mockSession) LocalAddr() net.Addr                     { panic("not implemented") }
+func (s *mockSession) RemoteAddr() net.Addr                    { return s.remoteAddr }
+func (*mockSession) Context() context.Context                  { panic("not implemented") }
+func (*mockSession) GetVersion() protocol.VersionNumber        { return protocol.VersionWhatever }
 
 var _ Session = &mockSession{}
 var _ NonFWSession = &mockSession{}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
@@ -73,6 +74,8 @@ type session struct {
 
 	streamFramer *streamFramer
 
+	datagramQueue *datagramQueue
+
 	flowControlManager flowcontrol.FlowControlManager
 
 	unpacker unpacker
@@ -298,6 +301,8 @@ func (s *session) setup(
 		s.perspective,
 		s.version,
 	)
+	s.datagramQueue = newDatagramQueue(s.scheduleSending)
+	s.packer.datagramQueue = s.datagramQueue
 	s.unpacker = &packetUnpacker{aead: s.cryptoSetup, version: s.version}
 
 	return s, handshakeChan, nil
@@ -532,6 +537,8 @@ func (s *session) handleFrames(fs []wire.Frame, p *path) error {
 			}
 		case *wire.ClosePathFrame:
 			s.handleClosePathFrame(frame)
+		case *wire.DatagramFrame:
+			s.datagramQueue.HandleDatagramFrame(frame)
 		case *wire.PathsFrame:
 			// So far, do nothing
 			s.pathsLock.RLock()
@@ -750,6 +757,7 @@ func (s *session) handleCloseError(closeErr closeError) error {
 	}
 
 	s.streamsMap.CloseWithError(quicErr)
+	s.datagramQueue.CloseWithError(quicErr)
 
 	if closeErr.err == errCloseSessionForNewVersion {
 		return nil
@@ -857,6 +865,67 @@ func (s *session) OpenStreamSync() (Stream, error) {
 	return s.streamsMap.OpenStreamSync()
 }
 
+// SendDatagram sends data in a DATAGRAM frame, which is acknowledged and
+// congestion controlled but never retransmitted
+func (s *session) SendDatagram(data []byte) error {
+	if protocol.ByteCount(len(data)) > protocol.MaxDatagramSize {
+		return wire.ErrDatagramTooLarge
+	}
+
+	f := &wire.DatagramFrame{Data: make([]byte, len(data))}
+	copy(f.Data, data)
+	return s.datagramQueue.AddAndWait(f)
+}
+
+// SendDatagramOnPath is SendDatagram on one path, the scheduler prefers a path
+// with datagrams waiting for it while its congestion window allows
+func (s *session) SendDatagramOnPath(data []byte, pathID PathID) error {
+	if protocol.ByteCount(len(data)) > protocol.MaxDatagramSize {
+		return wire.ErrDatagramTooLarge
+	}
+
+	s.pathsLock.RLock()
+	pth, ok := s.paths[pathID]
+	usable := ok && !pth.potentiallyFailed.Get()
+	s.pathsLock.RUnlock()
+
+	f := &wire.DatagramFrame{Data: make([]byte, len(data))}
+	copy(f.Data, data)
+	if !usable {
+		return s.datagramQueue.AddAndWait(f)
+	}
+	return s.datagramQueue.AddOnPathAndWait(f, pathID)
+}
+
+// PathStats returns the sender's view of every path, sorted by path ID
+func (s *session) PathStats() []PathStats {
+	s.pathsLock.RLock()
+	defer s.pathsLock.RUnlock()
+
+	stats := make([]PathStats, 0, len(s.paths))
+	for pathID, pth := range s.paths {
+		sent, retrans, lost := pth.sentPacketHandler.GetStatistics()
+		stats = append(stats, PathStats{
+			PathID:            pathID,
+			SmoothedRTT:       pth.rttStats.SmoothedRTT(),
+			CongestionWindow:  uint64(pth.sentPacketHandler.GetCongestionWindow()),
+			BytesInFlight:     uint64(pth.sentPacketHandler.GetBytesInFlight()),
+			PacketsSent:       sent,
+			PacketsRetrans:    retrans,
+			PacketsLost:       lost,
+			PotentiallyFailed: pth.potentiallyFailed.Get(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].PathID < stats[j].PathID })
+
+	return stats
+}
+
+// ReceiveDatagram returns the payload of the next DATAGRAM frame
+func (s *session) ReceiveDatagram() ([]byte, error) {
+	return s.datagramQueue.Receive()
+}
+
 func (s *session) WaitUntilHandshakeComplete() error {
 	return <-s.handshakeCompleteChan
 }
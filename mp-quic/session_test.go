@@ -102,6 +102,8 @@ func (h *mockSentPacketHandler) ShouldSendRetransmittablePacket() bool {
 	return b
 }
 func (h *mockSentPacketHandler) GetStatistics() (uint64, uint64, uint64) { panic("not implemented") }
+func (h *mockSentPacketHandler) GetCongestionWindow() protocol.ByteCount { panic("not implemented") }
+func (h *mockSentPacketHandler) GetBytesInFlight() protocol.ByteCount    { panic("not implemented") }
 
 func (h *mockSentPacketHandler) GetStopWaitingFrame(force bool) *wire.StopWaitingFrame {
 	h.requestedStopWaiting = true
@@ -0,0 +1,195 @@
+package xnc
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/itzmeanjan/kodr"
+	"github.com/itzmeanjan/kodr/full"
+)
+
+// CacheStats counts how the chunk cache of a Server did since it started
+type CacheStats struct {
+	// chunks found split in the cache, and chunks read from the file
+	Hits   int64
+	Misses int64
+	// chunks dropped to stay within Config.CacheBytes
+	Evictions int64
+	// coded pieces sent from a pregenerated pool instead of coded anew
+	PoolPieces int64
+	// what the cache holds now
+	Bytes  int64
+	Chunks int
+}
+
+// chunkCache keeps the split chunks of the files a Server sends, shared by
+// every request for the same file, range and split, and drops the least
+// recently used chunks once it holds more than max bytes. A file which was
+// replaced has another size or modification time, its chunks miss.
+type chunkCache struct {
+	max int64
+	// coded pieces pregenerated per chunk for full RLNC requests
+	poolSize int
+
+	mutex sync.Mutex
+	// most recently used first
+	lru     *list.List
+	entries map[chunkKey]*list.Element
+	stats   CacheStats
+}
+
+// fileKey is a file as one request opened it
+type fileKey struct {
+	name    string
+	size    int64
+	modTime int64
+	// the range of the file being coded
+	offset int64
+	length int64
+}
+
+type chunkKey struct {
+	file       fileKey
+	chunkSize  int
+	pieceCount int
+	id         int
+}
+
+// cachedChunk is one chunk read and split into pieces, shared read only by
+// every request coding it
+type cachedChunk struct {
+	key chunkKey
+	// chunk size without the zero padding
+	size int
+	// padded chunk, pieces are slices of it
+	data   []byte
+	pieces []kodr.Piece
+	// digest of the chunk, only set if the request which read it asked
+	// for digests
+	digest []byte
+
+	poolOnce sync.Once
+	pool     []*kodr.CodedPiece
+	// guarded by chunkCache.mutex
+	poolBytes int64
+}
+
+func newChunkCache(max int64, poolSize int) *chunkCache {
+	return &chunkCache{
+		max:      max,
+		poolSize: poolSize,
+		lru:      list.New(),
+		entries:  make(map[chunkKey]*list.Element),
+	}
+}
+
+// cost is the memory held by a cached chunk
+func (c *cachedChunk) cost() int64 {
+	return int64(len(c.data)) + c.poolBytes
+}
+
+// get returns the chunk of key if it is cached
+func (c *chunkCache) get(key chunkKey) (*cachedChunk, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.stats.Hits++
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cachedChunk), true
+}
+
+// add caches chunk unless another request already did, a chunk larger
+// than the whole cache is never kept
+func (c *chunkCache) add(chunk *cachedChunk) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.entries[chunk.key]; ok || chunk.cost() > c.max {
+		return
+	}
+
+	c.entries[chunk.key] = c.lru.PushFront(chunk)
+	c.stats.Bytes += chunk.cost()
+	c.stats.Chunks++
+	c.evict()
+}
+
+// evict drops the least recently used chunks until the cache fits, the
+// mutex must be held
+func (c *chunkCache) evict() {
+	for c.stats.Bytes > c.max && c.lru.Len() > 0 {
+		chunk := c.lru.Remove(c.lru.Back()).(*cachedChunk)
+		delete(c.entries, chunk.key)
+		c.stats.Bytes -= chunk.cost()
+		c.stats.Chunks--
+		c.stats.Evictions++
+	}
+}
+
+// pool returns the coded pieces pregenerated for chunk, coding them the
+// first time they are asked for
+func (c *chunkCache) pool(chunk *cachedChunk) []*kodr.CodedPiece {
+	chunk.poolOnce.Do(func() {
+		enc := full.NewFullRLNCEncoder(chunk.pieces)
+		pool := make([]*kodr.CodedPiece, c.poolSize)
+		for i := range pool {
+			pool[i] = enc.CodedPiece()
+		}
+		chunk.pool = pool
+
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		chunk.poolBytes = int64(c.poolSize) * int64(enc.CodedPieceLen())
+		if elem, ok := c.entries[chunk.key]; ok && elem.Value == chunk {
+			c.stats.Bytes += chunk.poolBytes
+			c.evict()
+		}
+	})
+
+	return chunk.pool
+}
+
+// Stats returns a snapshot of the counters
+func (c *chunkCache) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.stats
+}
+
+// pooledEncoder hands out the pregenerated pieces of a chunk, then codes
+// new ones like full.FullRLNCEncoder
+type pooledEncoder struct {
+	cache *chunkCache
+	pool  []*kodr.CodedPiece
+	next  int
+	enc   *randEncoder
+}
+
+func (e *pooledEncoder) CodedPiece() *kodr.CodedPiece {
+	if e.next >= len(e.pool) {
+		return e.enc.CodedPiece()
+	}
+
+	piece := e.pool[e.next]
+	e.next++
+
+	e.cache.mutex.Lock()
+	e.cache.stats.PoolPieces++
+	e.cache.mutex.Unlock()
+
+	return piece
+}
+
+// skip passes over the next n pooled pieces, so a chunk coded again
+// doesn't repeat pieces the client may already hold
+func (e *pooledEncoder) skip(n int) {
+	e.next += n
+}
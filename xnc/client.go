@@ -1,161 +1,377 @@
 package xnc
 
 import (
-	"crypto/tls"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
+	"sync"
 	"time"
 
-	"github.com/itzmeanjan/kodr"
-	"github.com/itzmeanjan/kodr/full"
 	"github.com/lucas-clemente/quic-go"
 )
 
-func Client(filename string, encode bool) ([]byte, time.Duration, float64) {
-	rand.Seed(42)
+// Client requests files from one xnc server with the chunk size and piece
+// count of its Config. All requests share one Conn, dialed by the first
+// request and again after the session is lost, so handshakes and the
+// congestion window are amortised over a whole streaming run. Requests can
+// run concurrently, each on its own stream. Chunk coded requests also
+// fetch from the mirrors of the Config, each on its own Conn, and resume
+// with the chunks they already decoded when the session drops.
+type Client struct {
+	conf *Config
 
-	fmt.Printf("[Client] Starting client, request file %v\n", filename)
-	quicConf := &quic.Config{}
-	sess, err := quic.DialAddr(serveraddr, &tls.Config{InsecureSkipVerify: true}, quicConf)
-	if err != nil {
-		fmt.Printf("[Client] Error dialing server: %v", err)
+	mutex   sync.Mutex
+	conn    *Conn
+	mirrors map[string]*Conn
+	// what every server contributed to the finished requests
+	stats []SourceStats
+	// the last transferHistory finished requests, oldest first
+	transfers []TransferStats
+	// decoded chunks of the chunk coded requests which didn't finish yet,
+	// by resumeKey
+	states map[string]*transferState
+}
+
+// NewClient copies conf, unset fields are taken from DefaultConfig
+func NewClient(conf *Config) (*Client, error) {
+	conf = conf.withDefaults()
+
+	if err := conf.Validate(); err != nil {
+		return nil, err
 	}
 
-	stream, err := sess.OpenStreamSync()
-	if err != nil {
-		fmt.Printf("[Client] Error opening stream: %v", err)
+	stats := make([]SourceStats, 0, len(conf.Mirrors)+1)
+	for _, addr := range append([]string{conf.Addr}, conf.Mirrors...) {
+		stats = append(stats, SourceStats{Addr: addr})
 	}
 
-	var initType byte
-	var frameSize int
-	var chunk []byte
+	return &Client{conf: conf, mirrors: make(map[string]*Conn), stats: stats, states: make(map[string]*transferState)}, nil
+}
 
-	if encode {
-		initType = TYPE_INIT_ENC
-		frameSize = FRAMESIZE_ENC
-	} else {
-		initType = TYPE_INIT
-		frameSize = FRAMESIZE
-		chunk = make([]byte, 0, CHUNKSIZE)
+// Conn returns the client's session, dialing it if there is none yet or
+// the last one was closed
+func (c *Client) Conn(ctx context.Context) (*Conn, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.conn != nil && !c.conn.closed() {
+		return c.conn, nil
 	}
 
-	initpkt, err := EncodeInit(XNC_INIT{
-		Type:     initType,
-		Len:      len(filename),
-		Filename: filename,
-	})
+	fmt.Printf("[Client] Dialing %v\n", c.conf.Addr)
+	conn, err := Dial(ctx, c.conf)
 	if err != nil {
-		fmt.Printf("[Client] Error encoding init packet: %v", err)
+		return nil, err
 	}
+	c.conn = conn
 
-	// Send the filename
-	_, err = stream.Write(initpkt)
-	if err != nil {
-		fmt.Printf("[Client] Error writing init packet: %v", err)
+	return conn, nil
+}
+
+// mirrorConns returns a session to every mirror which can be reached,
+// dialing the ones there is none to yet or whose last one was closed
+func (c *Client) mirrorConns(ctx context.Context) []*Conn {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	conns := make([]*Conn, 0, len(c.conf.Mirrors))
+	for _, addr := range c.conf.Mirrors {
+		if conn := c.mirrors[addr]; conn != nil && !conn.closed() {
+			conns = append(conns, conn)
+			continue
+		}
+
+		conf := *c.conf
+		conf.Addr = addr
+		conf.Mirrors = nil
+
+		fmt.Printf("[Client] Dialing mirror %v\n", addr)
+		conn, err := Dial(ctx, &conf)
+		if err != nil {
+			fmt.Printf("[Client] Error dialing mirror %v: %v\n", addr, err)
+			delete(c.mirrors, addr)
+			continue
+		}
+		c.mirrors[addr] = conn
+		conns = append(conns, conn)
 	}
 
-	startTime := time.Now()
-	totalBytesRead := 0
+	return conns
+}
 
-	rFile := make([]byte, 0)
+// Sources returns what every server contributed to the requests finished
+// so far, Addr first and then the mirrors
+func (c *Client) Sources() []SourceStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	var decoders []*full.FullRLNCDecoder
+	return append([]SourceStats(nil), c.stats...)
+}
 
-	for {
-		accu_recv := 0
-		pktE := make([]byte, frameSize)
-
-		for accu_recv < frameSize {
-			n, err := stream.Read(pktE[accu_recv:])
-			if err != nil {
-				if err == io.EOF {
-					fmt.Errorf("[Client] Stream closed by server")
-					break
-				}
-				fmt.Println("[Client] Error reading from stream:", err)
-				continue // or handle the error appropriately
-			} else {
-				accu_recv += n
-				totalBytesRead += n
+// Transfers returns the stats of the last transferHistory requests which
+// finished, oldest first
+func (c *Client) Transfers() []TransferStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append([]TransferStats(nil), c.transfers...)
+}
+
+// record adds what the servers contributed to r to the client's totals,
+// and the stats of r to its transfers
+func (c *Client) record(r *Reader) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.transfers = append(c.transfers, r.Stats())
+	if len(c.transfers) > transferHistory {
+		c.transfers = c.transfers[len(c.transfers)-transferHistory:]
+	}
+
+	for _, src := range r.Sources() {
+		for i := range c.stats {
+			if c.stats[i].Addr == src.Addr {
+				c.stats[i].Pieces += src.Pieces
+				c.stats[i].Innovative += src.Innovative
+				c.stats[i].Bytes += src.Bytes
+				break
 			}
 		}
+	}
+}
+
+// Close closes the client's sessions, a later request dials new ones
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for addr, conn := range c.mirrors {
+		conn.Close()
+		delete(c.mirrors, addr)
+	}
+
+	if c.conn == nil {
+		return nil
+	}
+
+	err := c.conn.Close()
+	c.conn = nil
+
+	return err
+}
+
+// open opens filename on the client's session, redialing once if the
+// session turns out to be lost. Chunk coded requests are also sent to the
+// mirrors, and resume with the chunks in state if it isn't nil.
+func (c *Client) open(ctx context.Context, filename string, initType byte, offset int64, length int64, state *transferState) (*Conn, *Reader, error) {
+	var mirrors []*Conn
+	if initType == TYPE_INIT_ENC && len(c.conf.Mirrors) > 0 {
+		mirrors = c.mirrorConns(ctx)
+	}
 
-		xncD, err := DecodeXNCPkt(pktE)
+	for attempt := 0; ; attempt++ {
+		conn, err := c.Conn(ctx)
 		if err != nil {
-			fmt.Printf("[Client] Error decoding packet data: %v", err)
-			return nil, sess.GetRtt(), 0
+			return nil, nil, err
 		}
 
-		if xncD.Type == TYPE_END {
-			fmt.Printf("[Client] Received END packet\n")
-			break
+		r, err := conn.openFrom(ctx, filename, initType, offset, length, mirrors, state)
+		if err == nil {
+			return conn, r, nil
+		}
+		if attempt > 0 || !conn.closed() {
+			return conn, nil, err
 		}
+		fmt.Printf("[Client] Session lost, redialing: %v\n", err)
+	}
+}
 
-		if encode {
-			if decoders == nil {
-				decoders = make([]*full.FullRLNCDecoder, xncD.ChunkNum)
-				for i := 0; i < xncD.ChunkNum; i++ {
-					decoders[i] = full.NewFullRLNCDecoder(PIECECNT)
-				}
-			}
+// Get returns the whole file once it is received along with the rtt and
+// the throughput on the wire. Errors are *Error of kind ErrDial,
+// ErrNotFound, ErrForbidden, ErrServer, ErrDecode, ErrIntegrity or
+// ErrTimeout, or ctx.Err() if ctx is canceled.
+func (c *Client) Get(ctx context.Context, filename string, encode bool) ([]byte, time.Duration, float64, error) {
+	return c.GetRange(ctx, filename, 0, 0, encode)
+}
 
-			pieceD := &kodr.CodedPiece{
-				Vector: xncD.Vector,
-				Piece:  xncD.Piece,
+// GetRange is Get for the length bytes of filename starting at offset, or
+// up to the end of the file if length is 0
+func (c *Client) GetRange(ctx context.Context, filename string, offset int64, length int64, encode bool) ([]byte, time.Duration, float64, error) {
+	return c.StreamRange(ctx, filename, offset, length, encode, nil)
+}
+
+// StreamRange is GetRange handing the data to deliver (if not nil) in
+// order as soon as it is read. A request resumed after its session dropped
+// only hands over the data which wasn't delivered before.
+func (c *Client) StreamRange(ctx context.Context, filename string, offset int64, length int64, encode bool, deliver func(data []byte)) ([]byte, time.Duration, float64, error) {
+	fmt.Printf("[Client] Starting client, request file %v\n", filename)
+
+	if !encode {
+		conn, r, err := c.open(ctx, filename, TYPE_INIT, offset, length, nil)
+		if err != nil {
+			if conn == nil {
+				return nil, 0, 0, err
 			}
+			return nil, conn.Rtt(), 0, err
+		}
 
-			if err := decoders[xncD.ChunkId].AddPiece(pieceD); err != nil {
-				if errors.Is(err, kodr.ErrAllUsefulPiecesReceived) {
-					// fmt.Printf("[Client] All useful pieces received\n")
-					continue
-				} else {
-					fmt.Printf("[Client] Error adding pieces: %v", err)
-					return nil, sess.GetRtt(), 0
-				}
+		defer c.record(r)
+		return readAll(conn, r, deliver)
+	}
+
+	return c.fetch(ctx, filename, TYPE_INIT_ENC, offset, length, deliver)
+}
+
+// GetSystematic is Get with systematic coding, see Conn.OpenSystematic
+func (c *Client) GetSystematic(ctx context.Context, filename string) ([]byte, time.Duration, float64, error) {
+	fmt.Printf("[Client] Starting systematic client, request file %v\n", filename)
+
+	return c.fetch(ctx, filename, TYPE_INIT_SYS, 0, 0, nil)
+}
+
+// fetch reads a chunk coded request to the end. When the session drops
+// the request is opened again on a new one, up to maxResumes times, and
+// the server only sends the chunks which weren't decoded yet. The chunks
+// are kept for a later request of the same range after other errors.
+// Every byte is handed to deliver (if not nil) once, a resumed request
+// reads the kept chunks again from the start.
+func (c *Client) fetch(ctx context.Context, filename string, initType byte, offset int64, length int64, deliver func(data []byte)) ([]byte, time.Duration, float64, error) {
+	key := resumeKey(c.conf, filename, offset, length)
+	state := c.transferState(key)
+
+	// bytes handed to deliver so far, and read by the current attempt
+	delivered, read := 0, 0
+	var once func(data []byte)
+	if deliver != nil {
+		once = func(data []byte) {
+			end := read + len(data)
+			if end > delivered {
+				deliver(data[len(data)-(end-delivered):])
+				delivered = end
 			}
-			// loss debug
-			// fmt.Printf("[Client] Chunk %d, recv %d, need %d\n", xncD.ChunkId, decoders[xncD.ChunkId].GetRecv(), decoders[xncD.ChunkId].GetExpt())
-
-			if decoders[xncD.ChunkId].IsDecoded() {
-				recvfile, err := GetFile(decoders[xncD.ChunkId])
-				if err != nil {
-					fmt.Printf("[Client] Error geting file: %v", err)
-					return nil, sess.GetRtt(), 0
-				}
-
-				rFile = append(rFile, recvfile[:xncD.ChunkSize]...)
-
-				if xncD.ChunkId == xncD.ChunkNum-1 {
-					fmt.Printf("[Client] Finished decoding file\n")
-					break
-				}
+			read = end
+		}
+	}
+
+	for resumes := 0; ; resumes++ {
+		read = 0
+		conn, r, err := c.open(ctx, filename, initType, offset, length, state)
+		if err != nil {
+			if conn == nil {
+				return nil, 0, 0, err
 			}
-		} else {
-			chunk = append(chunk, xncD.Piece...)
-			if len(chunk) == CHUNKSIZE {
-				// fmt.Printf("[Client] Received chunk %v\n", xncD.ChunkId)
-
-				rFile = append(rFile, chunk[:xncD.ChunkSize]...)
-				chunk = make([]byte, 0, CHUNKSIZE)
-
-				if xncD.ChunkId == xncD.ChunkNum-1 {
-					fmt.Printf("[Client] Finished decoding file\n")
-					break
-				}
+			return nil, conn.Rtt(), 0, err
+		}
+
+		data, rtt, kbps, err := readAll(conn, r, once)
+		c.record(r)
+
+		if err == nil || errors.Is(err, ErrIntegrity) || errors.Is(err, ErrNotFound) || errors.Is(err, ErrForbidden) {
+			c.dropState(key, state)
+			return data, rtt, kbps, err
+		}
+		if resumes >= maxResumes || ctx.Err() != nil || errors.Is(err, ErrDecode) || errors.Is(err, ErrServer) || !conn.dropped() {
+			return data, rtt, kbps, err
+		}
+		fmt.Printf("[Client] Session lost with %v chunks decoded, resuming: %v\n", state.count(), err)
+	}
+}
+
+// transferState returns the decoded chunks of the request named by key
+func (c *Client) transferState(key string) *transferState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	state, ok := c.states[key]
+	if !ok {
+		state = newTransferState(c.conf.ResumeDir, key)
+		c.states[key] = state
+	}
+
+	return state
+}
+
+// dropState forgets the decoded chunks of a request which is over
+func (c *Client) dropState(key string, state *transferState) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.states[key] == state {
+		delete(c.states, key)
+	}
+	state.remove()
+}
+
+// writeACKs encodes and writes the client's feedback until acks is closed
+func writeACKs(stream quic.Stream, acks <-chan XNC_ACK, done chan<- struct{}) {
+	defer close(done)
+
+	for ack := range acks {
+		pkt, err := EncodeACK(ack)
+		if err != nil {
+			fmt.Printf("[Client] Error encoding ack packet: %v\n", err)
+			continue
+		}
+
+		if _, err := stream.Write(pkt); err != nil {
+			fmt.Printf("[Client] Error writing ack packet: %v\n", err)
+			// keep draining so the read loop never blocks on a dead stream
+			for range acks {
 			}
+			return
 		}
 	}
+}
 
-	stream.Context().Done()
-	stream.Close()
+// GetWindow requests filename in sliding window mode, coding windows span
+// PieceCount pieces. Data is handed to deliver (if not nil) in order as
+// soon as it is decoded, the whole file is returned once the last piece
+// arrives.
+func (c *Client) GetWindow(ctx context.Context, filename string, deliver func(piece []byte)) ([]byte, time.Duration, float64, error) {
+	fmt.Printf("[Client] Starting window client, request file %v\n", filename)
+
+	conn, r, err := c.open(ctx, filename, TYPE_INIT_SW, 0, 0, nil)
+	if err != nil {
+		if conn == nil {
+			return nil, 0, 0, err
+		}
+		return nil, conn.Rtt(), 0, err
+	}
+
+	defer c.record(r)
+	return readAll(conn, r, deliver)
+}
+
+// readAll reads r to the end, handing every read to deliver (if not nil)
+func readAll(conn *Conn, r *Reader, deliver func(data []byte)) ([]byte, time.Duration, float64, error) {
+	defer r.Close()
+
+	rFile := make([]byte, 0)
+	for {
+		buf := make([]byte, conn.conf.PieceSize())
+		n, err := r.Read(buf)
+		if n > 0 {
+			rFile = append(rFile, buf[:n]...)
+			if deliver != nil {
+				deliver(buf[:n])
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, conn.Rtt(), 0, err
+		}
+	}
 
-	duration := float64(time.Since(startTime).Microseconds()) / 1000000.0
-	kbps := float64(totalBytesRead*8) / duration / 1000.
+	kbps := r.Kbps()
+	stats := r.Stats()
 	fmt.Printf("[Client] Received data at %.2f kbps\n", kbps)
-	fmt.Printf("[Client] Rtt %v\n", sess.GetRtt())
+	fmt.Printf("[Client] %v pieces, %v innovative, %v linearly dependent, redundancy %.3f, goodput %.2f kbps\n", stats.Pieces, stats.Innovative, stats.Dependent, stats.Redundancy(), stats.Goodput())
+	fmt.Printf("[Client] Rtt %v\n", conn.Rtt())
 	fmt.Printf("[Client] Finished recieving file\n")
 
-	return rFile, sess.GetRtt(), kbps
+	return rFile, conn.Rtt(), kbps, nil
 }
@@ -1,11 +1,185 @@
 package xnc
 
-var CHUNKSIZE int = 1 << 14
+import (
+	"crypto/tls"
+	"fmt"
+	"math"
 
-var RootDir string = "/var/www/html/tos_4sec_full/4K_dataset/4_sec/x264/bbb/DASH_Files/full/"
+	"github.com/lucas-clemente/quic-go"
+)
+
+const (
+	DefaultChunkSize   int    = 1 << 14
+	DefaultPieceCount  int    = 16
+	DefaultInterleave  int    = 1
+	DefaultGenerations int    = 16
+	DefaultAddr        string = "localhost:4242"
+	DefaultRootDir     string = "/var/www/html/tos_4sec_full/4K_dataset/4_sec/x264/bbb/DASH_Files/full/"
+)
+
+// limits the server accepts from a client's init packet
+const (
+	MAXCHUNKSIZE  int = 1 << 24
+	MAXPIECECOUNT int = 256
+	// with seeded coefficients, where frames don't carry a coding vector
+	MAXSEEDPIECECOUNT int = 1024
+)
 
 var TestFile string = "test.m4s"
 
-var clientaddr string = "localhost:4242"
+// Config holds the settings of one xnc server or client.
+//
+// ChunkSize and PieceCount are proposed by the client in the init packet,
+// the server codes each request with whatever its client asked for and
+// carries both in every data frame.
+type Config struct {
+	// server: address to listen on, client: server to dial
+	Addr string
+	// server only: directory files are served from
+	RootDir string
+	// server only: where files are read from, DirSource(RootDir) if nil
+	Files FileSource
+	// bytes coded together in one generation
+	ChunkSize int
+	// pieces per generation, which is also the coding vector length
+	PieceCount int
+	// client only: receive coded pieces as unreliable QUIC datagrams
+	// instead of on the stream
+	Datagram bool
+	// client only: have the server send a digest of every chunk and of the
+	// whole file, and check the decoded data against them. Sliding window
+	// transfers only check the whole file, once all of it was read.
+	Digest bool
+	// client only: coded frames carry a seed of their coefficients instead
+	// of the whole coding vector, which allows up to MAXSEEDPIECECOUNT
+	// pieces per chunk. Sliding window transfers always carry vectors.
+	Seed bool
+	// server only: bytes of split chunks kept across requests, so clients
+	// fetching the same segments don't read and split them again. 0
+	// turns the cache off.
+	CacheBytes int64
+	// server only: coded pieces pregenerated once per cached chunk and
+	// handed to every full RLNC request for it, before coding new ones
+	CachePool int
+	// server only: local address of an HTTP endpoint serving the
+	// server's counters in the Prometheus text format, at any path. No
+	// endpoint if empty.
+	MetricsAddr string
+	// server only: on a multipath session, send the datagrams a chunk
+	// needs on the path with the lowest RTT and its redundancy on the
+	// lossiest of the other paths instead of leaving paths to mp-quic
+	Steer bool
+	// server only: number of new chunks whose pieces are sent in turn,
+	// so a loss burst costs each of them a few pieces instead of wiping
+	// out one chunk. At most the 8 chunks in flight, 1 sends chunks one
+	// by one.
+	Interleave int
+	// client only: chunks kept open at once, being decoded or decoded and
+	// waiting for the chunks before them. The server holds back new chunks
+	// until the first open one is decoded, which bounds the decoder memory
+	// of large files.
+	Generations int
+	// client only: servers holding replicas of the files on Addr. Chunk
+	// coded requests fetch pieces from Addr and every mirror at once and
+	// decode them together, other requests only use Addr.
+	Mirrors []string
+	// client only: directory the decoded chunks of chunk coded requests
+	// are kept in until the request is over, so a request interrupted by
+	// a dropped session or a restart resumes with the chunks it is still
+	// missing. Chunks are only kept in memory if empty.
+	ResumeDir string
+	// server: certificates, loaded from ../godash/http/certs if nil
+	// client: skips certificate verification if nil
+	TLSConfig *tls.Config
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Addr:        DefaultAddr,
+		RootDir:     DefaultRootDir,
+		ChunkSize:   DefaultChunkSize,
+		PieceCount:  DefaultPieceCount,
+		Interleave:  DefaultInterleave,
+		Generations: DefaultGenerations,
+	}
+}
+
+// withDefaults returns a copy of c with every unset field taken from
+// DefaultConfig
+func (c *Config) withDefaults() *Config {
+	conf := DefaultConfig()
+	if c == nil {
+		return conf
+	}
+
+	copied := *c
+	if copied.Addr == "" {
+		copied.Addr = conf.Addr
+	}
+	if copied.RootDir == "" {
+		copied.RootDir = conf.RootDir
+	}
+	if copied.ChunkSize == 0 {
+		copied.ChunkSize = conf.ChunkSize
+	}
+	if copied.PieceCount == 0 {
+		copied.PieceCount = conf.PieceCount
+	}
+	if copied.Interleave == 0 {
+		copied.Interleave = conf.Interleave
+	}
+	if copied.Generations == 0 {
+		copied.Generations = conf.Generations
+	}
+
+	return &copied
+}
+
+// PieceSize is the size of one piece, ChunkSize split in PieceCount
+func (c *Config) PieceSize() int {
+	return c.ChunkSize / c.PieceCount
+}
+
+func (c *Config) Validate() error {
+	if c.CacheBytes < 0 || c.CachePool < 0 {
+		return fmt.Errorf("cache of %d bytes with %d pooled pieces is invalid\n", c.CacheBytes, c.CachePool)
+	}
+	if c.Interleave < 0 || c.Interleave > maxInFlight {
+		return fmt.Errorf("interleave %d is not in [0, %d]\n", c.Interleave, maxInFlight)
+	}
+	if c.Generations < 0 || c.Generations > math.MaxUint16 {
+		return fmt.Errorf("generations %d is out of range\n", c.Generations)
+	}
+	// every mirror codes with its own source number
+	if len(c.Mirrors) > math.MaxUint8 {
+		return fmt.Errorf("%d mirrors, at most %d\n", len(c.Mirrors), math.MaxUint8)
+	}
+
+	return validateParams(c.ChunkSize, c.PieceCount, c.Datagram, c.Seed)
+}
+
+// validateParams checks a chunk size and piece count pair, either from a
+// local Config or negotiated by a client
+func validateParams(chunkSize int, pieceCount int, datagram bool, seed bool) error {
+	maxPieceCount, vectorSize := MAXPIECECOUNT, pieceCount
+	if seed {
+		maxPieceCount, vectorSize = MAXSEEDPIECECOUNT, SEEDSIZE
+	}
+
+	if pieceCount <= 0 || pieceCount > maxPieceCount {
+		return fmt.Errorf("piece count %d is not in [1, %d]\n", pieceCount, maxPieceCount)
+	}
+	if chunkSize <= 0 || chunkSize > MAXCHUNKSIZE {
+		return fmt.Errorf("chunk size %d is not in [1, %d]\n", chunkSize, MAXCHUNKSIZE)
+	}
+	if chunkSize%pieceCount != 0 {
+		return fmt.Errorf("chunk size %d is not a multiple of piece count %d\n", chunkSize, pieceCount)
+	}
+
+	// a coded frame must fit in one datagram with its stream id
+	if frame := IDSIZE + HEADERSIZE + vectorSize + chunkSize/pieceCount; datagram && frame > quic.MaxDatagramSize {
+		return fmt.Errorf("frame size %d does not fit in a datagram\n", frame)
+	}
 
-var serveraddr string = "localhost:4242"
+	return nil
+}
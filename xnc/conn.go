@@ -0,0 +1,257 @@
+package xnc
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// Conn is a QUIC session to an xnc server, every file opened on it is
+// requested on its own stream. Files can be opened and read concurrently.
+type Conn struct {
+	conf *Config
+	sess quic.Session
+	// demultiplexes datagrams in datagram mode, nil otherwise
+	mux *datagramMux
+}
+
+// Dial connects to conf.Addr, unset fields of conf are taken from
+// DefaultConfig. Errors are of kind ErrDial, or ErrTimeout if ctx expires
+// first.
+func Dial(ctx context.Context, conf *Config) (*Conn, error) {
+	conf = conf.withDefaults()
+
+	if err := conf.Validate(); err != nil {
+		return nil, newError(ErrDial, err)
+	}
+
+	if conf.TLSConfig == nil {
+		conf.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	type dialResult struct {
+		sess quic.Session
+		err  error
+	}
+
+	// mp-quic can't cancel a handshake, a late session is closed right away
+	dialed := make(chan dialResult, 1)
+	go func() {
+		// a Conn is kept open between requests, pings stop it from idling out
+		quicConf := &quic.Config{KeepAlive: true}
+		sess, err := quic.DialAddr(conf.Addr, conf.TLSConfig, quicConf)
+		dialed <- dialResult{sess, err}
+	}()
+
+	var sess quic.Session
+	select {
+	case res := <-dialed:
+		if res.err != nil {
+			return nil, newError(ErrDial, res.err)
+		}
+		sess = res.sess
+	case <-ctx.Done():
+		go func() {
+			if res := <-dialed; res.sess != nil {
+				res.sess.Close(nil)
+			}
+		}()
+		return nil, ctxError(ctx)
+	}
+
+	c := &Conn{conf: conf, sess: sess}
+	if conf.Datagram {
+		c.mux = newDatagramMux(sess)
+	}
+
+	return c, nil
+}
+
+func (c *Conn) Rtt() time.Duration {
+	return c.sess.GetRtt()
+}
+
+// PathStats reports every path of the session, see Config.Steer
+func (c *Conn) PathStats() []quic.PathStats {
+	return c.sess.PathStats()
+}
+
+// Close closes the session along with every file still open on it
+func (c *Conn) Close() error {
+	return c.sess.Close(nil)
+}
+
+// closed reports whether the session is over, closed by either peer or
+// timed out
+func (c *Conn) closed() bool {
+	select {
+	case <-c.sess.Context().Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// dropped tells if the session is closed or closes within resumeWait, a
+// request sees the error of a dropped session before it is torn down
+func (c *Conn) dropped() bool {
+	select {
+	case <-c.sess.Context().Done():
+		return true
+	case <-time.After(resumeWait):
+		return false
+	}
+}
+
+// Open requests filename with full RLNC coding. The returned Reader yields
+// the file in order, each chunk as soon as it and every chunk before it
+// are decoded. The transfer is stopped once ctx is done.
+func (c *Conn) Open(ctx context.Context, filename string) (*Reader, error) {
+	return c.open(ctx, filename, TYPE_INIT_ENC, 0, 0)
+}
+
+// OpenRange is Open for the length bytes of filename starting at offset,
+// or up to the end of the file if length is 0. The server codes only that
+// range, a range running past the end of the file is cut short.
+func (c *Conn) OpenRange(ctx context.Context, filename string, offset int64, length int64) (*Reader, error) {
+	return c.open(ctx, filename, TYPE_INIT_ENC, offset, length)
+}
+
+// OpenRaw requests filename without coding, always on the stream
+func (c *Conn) OpenRaw(ctx context.Context, filename string) (*Reader, error) {
+	return c.open(ctx, filename, TYPE_INIT, 0, 0)
+}
+
+// OpenRawRange is OpenRaw for a range of filename, see OpenRange
+func (c *Conn) OpenRawRange(ctx context.Context, filename string, offset int64, length int64) (*Reader, error) {
+	return c.open(ctx, filename, TYPE_INIT, offset, length)
+}
+
+// OpenSystematic requests filename with systematic coding, each chunk is
+// sent uncoded first and only repaired with coded pieces, so the Reader
+// yields uncoded pieces as they arrive and only decodes chunks with loss
+func (c *Conn) OpenSystematic(ctx context.Context, filename string) (*Reader, error) {
+	return c.open(ctx, filename, TYPE_INIT_SYS, 0, 0)
+}
+
+// OpenSystematicRange is OpenSystematic for a range of filename, see
+// OpenRange
+func (c *Conn) OpenSystematicRange(ctx context.Context, filename string, offset int64, length int64) (*Reader, error) {
+	return c.open(ctx, filename, TYPE_INIT_SYS, offset, length)
+}
+
+// OpenWindow requests filename in sliding window mode, coding windows span
+// PieceCount pieces and the Reader yields every piece as soon as it can be
+// delivered in order.
+func (c *Conn) OpenWindow(ctx context.Context, filename string) (*Reader, error) {
+	return c.open(ctx, filename, TYPE_INIT_SW, 0, 0)
+}
+
+func (c *Conn) open(ctx context.Context, filename string, initType byte, offset int64, length int64) (*Reader, error) {
+	return c.openFrom(ctx, filename, initType, offset, length, nil, nil)
+}
+
+// openFrom is open with the pieces of filename also requested on every
+// mirror. Mirrors leave out digests and code with their own seed so their
+// pieces add to the ones from c, a mirror the request fails on is skipped.
+// With a state holding decoded chunks every server is asked for the
+// others only, and the Reader adds the chunks it decodes to state.
+func (c *Conn) openFrom(ctx context.Context, filename string, initType byte, offset int64, length int64, mirrors []*Conn, state *transferState) (*Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, ctxError(ctx)
+	}
+
+	fmt.Printf("[Client] Request file %v\n", filename)
+
+	var flags byte
+	if c.conf.Digest {
+		flags |= INITFLAG_DIGEST
+	}
+	if c.conf.Seed && (initType == TYPE_INIT_ENC || initType == TYPE_INIT_SYS) {
+		flags |= INITFLAG_SEED
+	}
+
+	var resume []byte
+	if state != nil {
+		if frame := state.resume(); frame != nil {
+			pkt, err := EncodeResume(*frame)
+			if err != nil {
+				return nil, newError(ErrServer, err)
+			}
+			resume = pkt
+			flags |= INITFLAG_RESUME
+			fmt.Printf("[Client] Resuming %v with %v of %v chunks\n", filename, state.count(), frame.ChunkNum)
+		}
+	}
+
+	init := XNC_INIT{
+		Type:       initType,
+		Flags:      flags,
+		ChunkSize:  c.conf.ChunkSize,
+		PieceCount: c.conf.PieceCount,
+		Offset:     offset,
+		Length:     length,
+		Window:     c.conf.Generations,
+		Len:        len(filename),
+		Filename:   filename,
+	}
+	stream, conn, err := c.request(init, resume)
+	if err != nil {
+		return nil, err
+	}
+
+	r := newReader(c, stream, conn, filename)
+	r.state = state
+
+	init.Flags &^= INITFLAG_DIGEST
+	for k, m := range mirrors {
+		init.Source = k + 1
+		stream, conn, err := m.request(init, resume)
+		if err != nil {
+			fmt.Printf("[Client] Error requesting %v from mirror %v: %v\n", filename, m.conf.Addr, err)
+			continue
+		}
+		r.srcs = append(r.srcs, newSource(m.conf.Addr, stream, conn))
+	}
+
+	go r.run(initType)
+	go r.watch(ctx)
+
+	return r, nil
+}
+
+// request opens a stream and sends init on it, followed by the resume
+// frame if there is one. Coded frames of the request come as datagrams if
+// the Conn receives datagrams, uncoded ones always come on the stream.
+// Errors are of kind ErrDial if the session can't carry the request, or
+// ErrServer if it can't be encoded.
+func (c *Conn) request(init XNC_INIT, resume []byte) (quic.Stream, *pktConn, error) {
+	stream, err := c.sess.OpenStreamSync()
+	if err != nil {
+		return nil, nil, newError(ErrDial, err)
+	}
+
+	mux := c.mux
+	if init.Type == TYPE_INIT {
+		mux = nil
+	}
+	if mux != nil {
+		init.Flags |= INITFLAG_DATAGRAM
+	}
+
+	initpkt, err := EncodeInit(init)
+	if err != nil {
+		stream.Close()
+		return nil, nil, newError(ErrServer, err)
+	}
+
+	if _, err := stream.Write(append(initpkt, resume...)); err != nil {
+		stream.Close()
+		return nil, nil, newError(ErrDial, err)
+	}
+
+	return stream, newRecvPktConn(c.sess, stream, mux), nil
+}
@@ -0,0 +1,81 @@
+package xnc
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+)
+
+// digestSet holds the digests the server sent for one request and checks
+// the decoded data against them. Chunk digests have the chunk id, the
+// digest of the whole file has the chunk count as its id.
+type digestSet struct {
+	count  int
+	chunks map[int][]byte
+	file   []byte
+	// every emitted byte in file order
+	hasher hash.Hash
+}
+
+func newDigestSet(count int) *digestSet {
+	return &digestSet{
+		count:  count,
+		chunks: make(map[int][]byte),
+		hasher: sha512.New512_224(),
+	}
+}
+
+func (d *digestSet) Add(id int, digest []byte) error {
+	if id < 0 || id > d.count {
+		return fmt.Errorf("digest of chunk %v is out of range\n", id)
+	}
+
+	if id == d.count {
+		d.file = digest
+	} else {
+		d.chunks[id] = digest
+	}
+
+	return nil
+}
+
+func (d *digestSet) Has(id int) bool {
+	_, ok := d.chunks[id]
+	return ok
+}
+
+func (d *digestSet) HasFile() bool {
+	return d.file != nil
+}
+
+// CheckChunk checks the data of chunk id, its digest has to be known
+func (d *digestSet) CheckChunk(id int, data []byte) error {
+	digest := sha512.Sum512_224(data)
+	if !bytes.Equal(digest[:], d.chunks[id]) {
+		return newError(ErrIntegrity, fmt.Errorf("chunk %v does not match its digest\n", id))
+	}
+
+	delete(d.chunks, id)
+	return nil
+}
+
+// Write adds data emitted in file order to the digest of the whole file
+func (d *digestSet) Write(data []byte) {
+	d.hasher.Write(data)
+}
+
+// CheckFile checks everything written so far against the file digest
+func (d *digestSet) CheckFile() error {
+	if !bytes.Equal(d.hasher.Sum(nil), d.file) {
+		return newError(ErrIntegrity, fmt.Errorf("file does not match its digest\n"))
+	}
+
+	return nil
+}
+
+// chunkDigest is the digest the server sends for data
+func chunkDigest(data []byte) []byte {
+	digest := sha512.Sum512_224(data)
+	return digest[:]
+}
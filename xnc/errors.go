@@ -0,0 +1,80 @@
+package xnc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kinds of errors returned by the client, match them with errors.Is
+var (
+	ErrDial     = errors.New("can't connect to xnc server")
+	ErrNotFound = errors.New("file not found on xnc server")
+	// the requested name is outside of the server's root, or unreadable
+	ErrForbidden = errors.New("file access forbidden on xnc server")
+	// the server rejected the request or failed to read the file
+	ErrServer = errors.New("xnc server error")
+	ErrDecode = errors.New("can't decode received pieces")
+	// decoded data doesn't match the digest sent by the server
+	ErrIntegrity = errors.New("xnc data failed verification")
+	ErrTimeout   = errors.New("xnc transfer timed out")
+)
+
+var errReaderClosed = errors.New("xnc reader closed")
+var errFetchStopped = errors.New("xnc relay fetch stopped")
+
+// Error is a client error of one of the kinds above, along with its cause
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func newError(kind error, err error) *Error {
+	return &Error{Kind: kind, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+	return e.Kind.Error() + ": " + strings.TrimSuffix(e.Err.Error(), "\n")
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ctxError is the error of a transfer stopped by ctx
+func ctxError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return newError(ErrTimeout, ctx.Err())
+	}
+	return ctx.Err()
+}
+
+// statusError is the error for a status other than STATUS_OK
+func statusError(status byte, filename string) error {
+	switch status {
+	case STATUS_OK:
+		return nil
+	case STATUS_NOT_FOUND:
+		return newError(ErrNotFound, errors.New(filename))
+	case STATUS_FORBIDDEN:
+		return newError(ErrForbidden, errors.New(filename))
+	case STATUS_BAD_REQUEST:
+		return newError(ErrServer, errors.New("bad request"))
+	default:
+		return newError(ErrServer, fmt.Errorf("status %d", status))
+	}
+}
+
+// isTimeout reports network timeouts, like QUIC's idle timeout
+func isTimeout(err error) bool {
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
@@ -14,15 +14,34 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel() // Ensures the server goroutine is terminated.
 
-	go xnc.Server(ctx, xnc.RootDir)
+	conf := xnc.DefaultConfig()
+
+	server, err := xnc.NewServer(conf)
+	if err != nil {
+		fmt.Printf("Error creating server: %v", err)
+		return
+	}
+
+	client, err := xnc.NewClient(conf)
+	if err != nil {
+		fmt.Printf("Error creating client: %v", err)
+		return
+	}
+	defer client.Close()
+
+	go server.ListenAndServe(ctx)
 	time.Sleep(1 * time.Second) // Wait for the server to initialize.
 
-	recvfile, _, _ := xnc.Client(xnc.TestFile, true)
+	recvfile, _, _, err := client.Get(ctx, xnc.TestFile, true)
+	if err != nil {
+		fmt.Printf("Error receiving file: %v\n", err)
+		return
+	}
 
 	// wait for the server to finish
 	time.Sleep(2 * time.Second)
 
-	original, err := ioutil.ReadFile(xnc.RootDir + xnc.TestFile)
+	original, err := ioutil.ReadFile(conf.RootDir + xnc.TestFile)
 	if err != nil {
 		fmt.Printf("Error opening original file: %v", err)
 	}
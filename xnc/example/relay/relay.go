@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/comp529/xnc"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:4243", "address clients connect to")
+	upstream := flag.String("upstream", xnc.DefaultAddr, "xnc server to fetch from")
+	datagram := flag.Bool("datagram", false, "fetch coded pieces as datagrams")
+	flag.Parse()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel() // Ensures the relay goroutines are terminated.
+
+	relay, err := xnc.NewRelay(&xnc.Config{Addr: *addr}, &xnc.Config{Addr: *upstream, Datagram: *datagram})
+	if err != nil {
+		fmt.Printf("Error creating relay: %v", err)
+		return
+	}
+
+	relay.ListenAndServe(ctx)
+
+	fmt.Println("Relay End")
+}
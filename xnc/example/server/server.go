@@ -2,16 +2,38 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 
 	"github.com/comp529/xnc"
 )
 
 func main() {
+	origin := flag.String("origin", "", "HTTP origin to serve files from instead of the root directory")
+	cache := flag.String("cache", "", "directory files from the origin are cached in")
+	chunkCache := flag.Int64("chunkcache", 0, "bytes of split chunks kept across requests")
+	pool := flag.Int("pool", 0, "coded pieces pregenerated per cached chunk")
+	metrics := flag.String("metrics", "", "local address serving Prometheus metrics, e.g. localhost:9100")
+	flag.Parse()
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel() // Ensures the server goroutine is terminated.
 
-	xnc.Server(ctx, xnc.RootDir)
+	conf := xnc.DefaultConfig()
+	conf.CacheBytes = *chunkCache
+	conf.CachePool = *pool
+	conf.MetricsAddr = *metrics
+	if *origin != "" {
+		conf.Files = xnc.NewHTTPSource(*origin, *cache)
+	}
+
+	server, err := xnc.NewServer(conf)
+	if err != nil {
+		fmt.Printf("Error creating server: %v", err)
+		return
+	}
+
+	server.ListenAndServe(ctx)
 
 	fmt.Println("Server End")
 }
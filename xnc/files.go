@@ -0,0 +1,287 @@
+package xnc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileSource is where a Server reads the files it serves from. Names are
+// the ones clients request, relative to the source even with a leading
+// slash. Errors of Open wrap fs.ErrNotExist or fs.ErrPermission so the
+// client gets ErrNotFound or ErrForbidden.
+type FileSource interface {
+	Open(name string) (File, error)
+}
+
+// File is a file opened from a FileSource, *os.File is one
+type File interface {
+	io.ReaderAt
+	io.Closer
+	Stat() (fs.FileInfo, error)
+}
+
+// DirSource serves the files under a local directory
+type DirSource string
+
+func (d DirSource) Open(name string) (File, error) {
+	filename, ok := resolvePath(string(d), name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return file, nil
+}
+
+// MemSource serves files held in memory, mostly for tests
+type MemSource struct {
+	mutex sync.RWMutex
+	files map[string]memEntry
+	// modification time of the last Put, kept increasing so that every
+	// Put is a new version to the generation cache
+	modTime time.Time
+}
+
+type memEntry struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemSource() *MemSource {
+	return &MemSource{files: make(map[string]memEntry)}
+}
+
+// Put stores data as name, replacing any file of that name. Requests
+// opened before keep reading the old data.
+func (m *MemSource) Put(name string, data []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	if !now.After(m.modTime) {
+		now = m.modTime.Add(time.Nanosecond)
+	}
+	m.modTime = now
+	m.files[cleanName(name)] = memEntry{data: data, modTime: now}
+}
+
+func (m *MemSource) Open(name string) (File, error) {
+	key, err := sourceName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	entry, ok := m.files[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return newMemFile(name, entry.data, entry.modTime), nil
+}
+
+// HTTPSource serves the files of an HTTP origin, such as the DASH server
+// the content already lives on. Every file is downloaded whole the first
+// time it is requested and kept under CacheDir, concurrent requests for a
+// file share one download. Without CacheDir nothing is kept and every
+// request downloads its file again.
+type HTTPSource struct {
+	// base URL the requested names are appended to
+	Origin string
+	// local directory of the downloaded files, nothing is cached if empty
+	CacheDir string
+	// http.DefaultClient if nil
+	Client *http.Client
+
+	mutex sync.Mutex
+	// downloads in progress, closed once the file is in the cache
+	fetching map[string]chan struct{}
+}
+
+func NewHTTPSource(origin string, cacheDir string) *HTTPSource {
+	return &HTTPSource{Origin: origin, CacheDir: cacheDir}
+}
+
+func (h *HTTPSource) Open(name string) (File, error) {
+	name, err := sourceName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.CacheDir == "" {
+		data, err := h.download(name)
+		if err != nil {
+			return nil, err
+		}
+		return newMemFile(name, data, time.Now()), nil
+	}
+
+	for {
+		file, err := DirSource(h.CacheDir).Open(name)
+		if err == nil || !os.IsNotExist(err) {
+			return file, err
+		}
+
+		h.mutex.Lock()
+		if h.fetching == nil {
+			h.fetching = make(map[string]chan struct{})
+		}
+		done, ok := h.fetching[name]
+		if ok {
+			// another request is downloading the file, it is opened from
+			// the cache once that download is over
+			h.mutex.Unlock()
+			<-done
+			file, err := DirSource(h.CacheDir).Open(name)
+			if err == nil || !os.IsNotExist(err) {
+				return file, err
+			}
+			continue
+		}
+		done = make(chan struct{})
+		h.fetching[name] = done
+		h.mutex.Unlock()
+
+		err = h.fetch(name)
+
+		h.mutex.Lock()
+		delete(h.fetching, name)
+		close(done)
+		h.mutex.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fetch downloads name into the cache, the file only appears there once
+// it is complete
+func (h *HTTPSource) fetch(name string) error {
+	filename := filepath.Join(h.CacheDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	data, err := h.download(name)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), ".xnc-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	fmt.Printf("[Server] Cached %v from %v, %v bytes\n", name, h.Origin, len(data))
+	return os.Rename(tmp.Name(), filename)
+}
+
+// download gets the whole of name from the origin
+func (h *HTTPSource) download(name string) ([]byte, error) {
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Get(strings.TrimSuffix(h.Origin, "/") + name)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case http.StatusForbidden, http.StatusUnauthorized:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	default:
+		return nil, fmt.Errorf("origin answered %v for %v\n", resp.Status, name)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// cleanName is the canonical form of a requested name, with a leading
+// slash and no "." or empty elements
+func cleanName(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+// sourceName is cleanName for a name which is refused like a DirSource
+// refuses it, so no source ever sees ".."
+func sourceName(name string) (string, error) {
+	if _, ok := resolvePath("/", name); !ok {
+		return "", &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return cleanName(name), nil
+}
+
+// memFile is a File over data held in memory
+type memFile struct {
+	*bytes.Reader
+	info memFileInfo
+}
+
+func newMemFile(name string, data []byte, modTime time.Time) *memFile {
+	return &memFile{
+		Reader: bytes.NewReader(data),
+		info:   memFileInfo{name: path.Base(name), size: int64(len(data)), modTime: modTime},
+	}
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) Mode() fs.FileMode  { return 0444 }
+func (i memFileInfo) ModTime() time.Time { return i.modTime }
+func (i memFileInfo) IsDir() bool        { return false }
+func (i memFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,295 @@
+package xnc
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+
+	"github.com/cloud9-tools/go-galoisfield"
+	"github.com/itzmeanjan/kodr"
+)
+
+// generations read and prepared for coding ahead of the sender
+const pipelineDepth int = 2
+
+// coefficients of a request are drawn from its own generator, seeded with
+// codingSeed plus the index of the server in a multi-source transfer
+const codingSeed int64 = 42
+
+// pieceEncoder hands out the coded pieces of one chunk
+type pieceEncoder interface {
+	CodedPiece() *kodr.CodedPiece
+}
+
+// randEncoder codes the pieces of one chunk like full.FullRLNCEncoder, or
+// like systematic.SystematicRLNCEncoder if systematic is set, with the
+// coefficients drawn from the generator of its request. The generator is
+// only used by the sender of the request.
+type randEncoder struct {
+	field      *galoisfield.GF
+	pieces     []kodr.Piece
+	systematic bool
+	rng        *rand.Rand
+	sent       int
+}
+
+func newRandEncoder(pieces []kodr.Piece, systematic bool, rng *rand.Rand) *randEncoder {
+	return &randEncoder{field: galoisfield.DefaultGF256, pieces: pieces, systematic: systematic, rng: rng}
+}
+
+func (e *randEncoder) CodedPiece() *kodr.CodedPiece {
+	if e.systematic && e.sent < len(e.pieces) {
+		e.sent++
+		return uncodedPiece(e.sent-1, e.pieces[e.sent-1], uint(len(e.pieces)))
+	}
+	e.sent++
+
+	vector := make(kodr.CodingVector, len(e.pieces))
+	e.rng.Read(vector)
+
+	piece := make(kodr.Piece, len(e.pieces[0]))
+	for i := range e.pieces {
+		piece.Multiply(e.pieces[i], vector[i], e.field)
+	}
+
+	return &kodr.CodedPiece{Vector: vector, Piece: piece}
+}
+
+// skip passes over the uncoded pieces among the next n, so a chunk coded
+// again goes on with coded pieces
+func (e *randEncoder) skip(n int) {
+	e.sent += n
+}
+
+// generation is one chunk of a file, ready to be sent
+type generation struct {
+	id int
+	// chunk size without the zero padding
+	size int
+	// padded chunk, only kept in raw mode
+	data []byte
+	// encoder of the chunk, only set in coded and systematic mode
+	enc pieceEncoder
+	// digest of the chunk, and of the whole file on the last generation,
+	// only set if the client asked for digests
+	digest     []byte
+	fileDigest []byte
+	// the client resumed the transfer with the chunk, it is neither coded
+	// nor, without digests, read
+	resumed bool
+}
+
+// generationReader reads a file chunk by chunk from an io.ReaderAt and
+// prepares the next pipelineDepth generations in the background, so the
+// server never holds more than the generations in flight plus the
+// pipeline in memory whatever the file size.
+type generationReader struct {
+	r          io.ReaderAt
+	size       int64
+	chunkSize  int
+	pieceCount uint
+	// TYPE_INIT, TYPE_INIT_ENC or TYPE_INIT_SYS
+	initType byte
+	// code with seeded coefficients
+	seed bool
+	// seeds of the server with this index in a multi-source transfer
+	source int
+	// coefficients of the request, without seeds
+	rng   *rand.Rand
+	count int
+	// digest of the chunks read so far, nil without digests
+	hasher hash.Hash
+	// chunks of the file shared with other requests, nil if not cached
+	cache *chunkCache
+	file  fileKey
+	// chunks the client already has, nil unless it resumed the transfer
+	resumed []bool
+
+	gens chan generation
+	// why the pipeline stopped, only read after gens is closed
+	err  error
+	done chan struct{}
+}
+
+// newGenerationReader reads the generations of r, taking chunks split by
+// earlier requests for file out of cache if it isn't nil. The chunks set
+// in resumed are handed out without an encoder.
+func newGenerationReader(r io.ReaderAt, size int64, conf *Config, initType byte, source int, cache *chunkCache, file fileKey, resumed []bool) *generationReader {
+	chunkSize := int64(conf.ChunkSize)
+
+	g := &generationReader{
+		r:          r,
+		size:       size,
+		chunkSize:  conf.ChunkSize,
+		pieceCount: uint(conf.PieceCount),
+		initType:   initType,
+		seed:       conf.Seed,
+		source:     source,
+		rng:        rand.New(rand.NewSource(codingSeed + int64(source))),
+		cache:      cache,
+		file:       file,
+		resumed:    resumed,
+		count:      int((size + chunkSize - 1) / chunkSize),
+		gens:       make(chan generation, pipelineDepth),
+		done:       make(chan struct{}),
+	}
+	if conf.Digest {
+		g.hasher = sha512.New512_224()
+	}
+	go g.run()
+
+	return g
+}
+
+// Count is the number of chunks in the file
+func (g *generationReader) Count() int {
+	return g.count
+}
+
+// Next returns the next generation in file order, io.EOF after the last
+func (g *generationReader) Next() (generation, error) {
+	gen, ok := <-g.gens
+	if !ok {
+		if g.err != nil {
+			return generation{}, g.err
+		}
+		return generation{}, io.EOF
+	}
+
+	return gen, nil
+}
+
+// Close stops reading ahead
+func (g *generationReader) Close() {
+	close(g.done)
+}
+
+func (g *generationReader) run() {
+	defer close(g.gens)
+
+	for i := 0; i < g.count; i++ {
+		gen, err := g.read(i)
+		if err != nil {
+			g.err = err
+			return
+		}
+
+		select {
+		case g.gens <- gen:
+		case <-g.done:
+			return
+		}
+	}
+}
+
+// Reopen reads chunk id again for a client which lost it after it was
+// sent, the generation carries no digests
+func (g *generationReader) Reopen(id int) (generation, error) {
+	chunk, err := g.load(id)
+	if err != nil {
+		return generation{}, err
+	}
+
+	gen := generation{id: id, size: chunk.size}
+	g.prepare(&gen, chunk)
+	return gen, nil
+}
+
+func (g *generationReader) read(id int) (generation, error) {
+	resumed := g.resumed != nil && g.resumed[id]
+	if resumed && g.hasher == nil {
+		return generation{id: id, size: g.sizeOf(id), resumed: true}, nil
+	}
+
+	chunk, err := g.load(id)
+	if err != nil {
+		return generation{}, err
+	}
+
+	gen := generation{id: id, size: chunk.size, resumed: resumed}
+	if g.hasher != nil {
+		gen.digest = chunk.digest
+		if gen.digest == nil {
+			gen.digest = chunkDigest(chunk.data[:chunk.size])
+		}
+		g.hasher.Write(chunk.data[:chunk.size])
+		if id == g.count-1 {
+			gen.fileDigest = g.hasher.Sum(nil)
+		}
+	}
+
+	if !resumed {
+		g.prepare(&gen, chunk)
+	}
+	return gen, nil
+}
+
+// sizeOf is the size of chunk id without its zero padding
+func (g *generationReader) sizeOf(id int) int {
+	if remain := g.size - int64(id)*int64(g.chunkSize); remain < int64(g.chunkSize) {
+		return int(remain)
+	}
+	return g.chunkSize
+}
+
+// load returns chunk id split into pieces, from the cache or read from the
+// file with the rest of the chunk zero padded
+func (g *generationReader) load(id int) (*cachedChunk, error) {
+	key := chunkKey{file: g.file, chunkSize: g.chunkSize, pieceCount: int(g.pieceCount), id: id}
+	if g.cache != nil {
+		if chunk, ok := g.cache.get(key); ok {
+			return chunk, nil
+		}
+	}
+
+	off := int64(id) * int64(g.chunkSize)
+	size := g.sizeOf(id)
+
+	data := make([]byte, g.chunkSize)
+	if n, err := g.r.ReadAt(data[:size], off); n < size {
+		return nil, fmt.Errorf("Error reading chunk %v: %v\n", id, err)
+	}
+
+	pieces, _, err := kodr.OriginalPiecesFromDataAndPieceCount(data, g.pieceCount)
+	if err != nil {
+		return nil, err
+	}
+
+	chunk := &cachedChunk{key: key, size: size, data: data, pieces: pieces}
+	if g.hasher != nil {
+		chunk.digest = chunkDigest(data[:size])
+	}
+	if g.cache != nil {
+		g.cache.add(chunk)
+	}
+
+	return chunk, nil
+}
+
+// prepare keeps the data of gen in raw mode, or sets up its encoder. The
+// pool of a cached chunk is shared by every request, only the first server
+// of a multi-source transfer takes pieces from it so a mirror never sends
+// the same ones.
+func (g *generationReader) prepare(gen *generation, chunk *cachedChunk) {
+	switch {
+	case g.initType == TYPE_INIT:
+		gen.data = chunk.data
+
+	case g.seed:
+		enc := NewSeededEncoder(chunk.pieces, gen.id, g.initType == TYPE_INIT_SYS)
+		enc.forSource(g.source)
+		gen.enc = enc
+
+	case g.initType == TYPE_INIT_ENC && g.source == 0 && g.cache != nil && g.cache.poolSize > 0:
+		gen.enc = &pooledEncoder{
+			cache: g.cache,
+			pool:  g.cache.pool(chunk),
+			enc:   newRandEncoder(chunk.pieces, false, g.rng),
+		}
+
+	default:
+		gen.enc = newRandEncoder(chunk.pieces, g.initType == TYPE_INIT_SYS, g.rng)
+	}
+}
@@ -0,0 +1,224 @@
+package xnc
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// finished requests a Client keeps the TransferStats of
+const transferHistory int = 64
+
+// upper bounds of the chunk decode time buckets of ServerStats
+var decodeBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+}
+
+// TransferStats describes one request as its Reader received it
+type TransferStats struct {
+	Filename string
+	// file bytes handed to Read, and bytes received on the wire including
+	// headers, digests and redundant pieces
+	Bytes         int
+	BytesReceived int
+	// data frames received from every server, the ones which raised the
+	// rank of their decoder, and the linearly dependent ones which didn't.
+	// The rest arrived for chunks which were already decoded.
+	Pieces     int
+	Innovative int
+	Dependent  int
+	// time from the first piece of every chunk to its decoding by chunk
+	// id, zero for chunks which weren't decoded by this request. Only set
+	// with chunk coding.
+	ChunkLatency []time.Duration
+	// chunk decoders open now and the most open at once, at most
+	// Generations. Only set with chunk coding.
+	Decoders    int
+	MaxDecoders int
+	Duration    time.Duration
+}
+
+// Redundancy is the number of pieces received beyond the innovative ones,
+// per innovative piece
+func (s TransferStats) Redundancy() float64 {
+	if s.Innovative == 0 {
+		return 0
+	}
+	return float64(s.Pieces-s.Innovative) / float64(s.Innovative)
+}
+
+// Goodput is the rate of file data delivered in kbps
+func (s TransferStats) Goodput() float64 {
+	return kbps(s.Bytes, s.Duration)
+}
+
+// Throughput is the rate on the wire in kbps
+func (s TransferStats) Throughput() float64 {
+	return kbps(s.BytesReceived, s.Duration)
+}
+
+func kbps(bytes int, elapsed time.Duration) float64 {
+	duration := float64(elapsed.Microseconds()) / 1000000.0
+	if duration == 0 {
+		return 0
+	}
+	return float64(bytes*8) / duration / 1000.
+}
+
+// ServerStats counts what a Server sent since it started
+type ServerStats struct {
+	// requests accepted, and the ones being served now
+	Requests int64
+	Active   int64
+	// data frames and bytes written, including digests and END frames in
+	// Bytes. Redundant pieces went out beyond what the client needed.
+	Pieces          int64
+	RedundantPieces int64
+	Bytes           int64
+	// chunks the clients reported decoded, and the bursts of pieces sent
+	// for chunks the clients were still missing
+	ChunksDecoded int64
+	Repairs       int64
+	// time from the first piece of a chunk to the client reporting it
+	// decoded, summed and counted per bucket of decodeBuckets, the last
+	// count is of the chunks slower than every bucket
+	DecodeTime    time.Duration
+	DecodeBuckets []int64
+}
+
+// serverMetrics collects the ServerStats of a Server, shared by every
+// request it serves
+type serverMetrics struct {
+	mutex sync.Mutex
+	stats ServerStats
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{stats: ServerStats{DecodeBuckets: make([]int64, len(decodeBuckets)+1)}}
+}
+
+// started counts a request which is being served, done is called once it
+// is over
+func (m *serverMetrics) started() (done func()) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.stats.Requests++
+	m.stats.Active++
+	return func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		m.stats.Active--
+	}
+}
+
+// sent counts a frame written for a request
+func (m *serverMetrics) sent(pkt []byte, redundant bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.stats.Bytes += int64(len(pkt))
+	if len(pkt) > 0 && pkt[0] != TYPE_END && pkt[0] != TYPE_DIGEST {
+		m.stats.Pieces++
+		if redundant {
+			m.stats.RedundantPieces++
+		}
+	}
+}
+
+// decoded counts a chunk which took elapsed from its first piece
+func (m *serverMetrics) decoded(elapsed time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.stats.ChunksDecoded++
+	m.stats.DecodeTime += elapsed
+
+	bucket := len(decodeBuckets)
+	for i, bound := range decodeBuckets {
+		if elapsed <= bound {
+			bucket = i
+			break
+		}
+	}
+	m.stats.DecodeBuckets[bucket]++
+}
+
+func (m *serverMetrics) repaired() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.stats.Repairs++
+}
+
+// Stats returns a snapshot of the counters
+func (m *serverMetrics) Stats() ServerStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stats := m.stats
+	stats.DecodeBuckets = append([]int64(nil), m.stats.DecodeBuckets...)
+	return stats
+}
+
+// writePrometheus writes stats and, if cache isn't nil, the chunk cache
+// counters in the Prometheus text format
+func writePrometheus(w io.Writer, stats ServerStats, cache *CacheStats) {
+	metric := func(name string, kind string, help string, value interface{}) {
+		fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n%v %v\n", name, help, name, kind, name, value)
+	}
+
+	metric("xnc_requests_total", "counter", "Requests accepted by the server.", stats.Requests)
+	metric("xnc_requests_active", "gauge", "Requests being served.", stats.Active)
+	metric("xnc_pieces_sent_total", "counter", "Data frames sent.", stats.Pieces)
+	metric("xnc_redundant_pieces_sent_total", "counter", "Coded pieces sent beyond what the clients needed.", stats.RedundantPieces)
+	metric("xnc_bytes_sent_total", "counter", "Bytes of every frame sent.", stats.Bytes)
+	metric("xnc_chunk_repairs_total", "counter", "Bursts sent for chunks the clients were still missing.", stats.Repairs)
+
+	name := "xnc_chunk_decode_seconds"
+	fmt.Fprintf(w, "# HELP %v Time from the first piece of a chunk to the client reporting it decoded.\n# TYPE %v histogram\n", name, name)
+	var count int64
+	for i, bound := range decodeBuckets {
+		count += stats.DecodeBuckets[i]
+		fmt.Fprintf(w, "%v_bucket{le=\"%v\"} %v\n", name, bound.Seconds(), count)
+	}
+	fmt.Fprintf(w, "%v_bucket{le=\"+Inf\"} %v\n", name, stats.ChunksDecoded)
+	fmt.Fprintf(w, "%v_sum %v\n%v_count %v\n", name, stats.DecodeTime.Seconds(), name, stats.ChunksDecoded)
+
+	if cache == nil {
+		return
+	}
+	metric("xnc_cache_hits_total", "counter", "Chunks found split in the chunk cache.", cache.Hits)
+	metric("xnc_cache_misses_total", "counter", "Chunks read from the file.", cache.Misses)
+	metric("xnc_cache_evictions_total", "counter", "Chunks dropped from the chunk cache.", cache.Evictions)
+	metric("xnc_cache_pool_pieces_total", "counter", "Coded pieces sent from a pregenerated pool.", cache.PoolPieces)
+	metric("xnc_cache_bytes", "gauge", "Bytes held by the chunk cache.", cache.Bytes)
+	metric("xnc_cache_chunks", "gauge", "Chunks held by the chunk cache.", cache.Chunks)
+}
+
+// MetricsHandler serves the server's counters in the Prometheus text
+// format
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var cache *CacheStats
+		if s.cache != nil {
+			stats := s.cache.Stats()
+			cache = &stats
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writePrometheus(w, s.metrics.Stats(), cache)
+	})
+}
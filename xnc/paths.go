@@ -0,0 +1,87 @@
+package xnc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// pathSteer picks the path each coded datagram of a request goes out on
+// when the session has more than one usable path. The pieces a chunk
+// needs go on the path with the lowest RTT, so uncoded systematic pieces
+// and the first coded ones arrive first, and redundancy goes on the path
+// losing the most packets of the others, where a loss costs the least.
+type pathSteer struct {
+	sess    quic.Session
+	updated time.Time
+	// both are only valid while steered is set
+	fast    quic.PathID
+	lossy   quic.PathID
+	steered bool
+}
+
+func newPathSteer(sess quic.Session) *pathSteer {
+	return &pathSteer{sess: sess}
+}
+
+// path returns the path of the next piece, or false if it can go on any
+func (p *pathSteer) path(redundant bool) (quic.PathID, bool) {
+	if time.Since(p.updated) >= steerInterval {
+		p.update(p.sess.PathStats())
+		p.updated = time.Now()
+	}
+
+	if !p.steered {
+		return 0, false
+	}
+	if redundant {
+		return p.lossy, true
+	}
+	return p.fast, true
+}
+
+// update picks the fast and the lossy path out of stats. The initial path
+// only carries the handshake once other paths are open, like mp-quic's own
+// scheduler it is left out then.
+func (p *pathSteer) update(stats []quic.PathStats) {
+	usable := make([]quic.PathStats, 0, len(stats))
+	for _, st := range stats {
+		if st.PotentiallyFailed || (st.PathID == 0 && len(stats) > 1) {
+			continue
+		}
+		usable = append(usable, st)
+	}
+
+	if len(usable) < 2 {
+		p.steered = false
+		return
+	}
+
+	fast := usable[0]
+	for _, st := range usable[1:] {
+		// a path without an RTT sample yet is never the fast one
+		if st.SmoothedRTT > 0 && (fast.SmoothedRTT == 0 || st.SmoothedRTT < fast.SmoothedRTT) {
+			fast = st
+		}
+	}
+	var lossy *quic.PathStats
+	for i, st := range usable {
+		if st.PathID != fast.PathID && (lossy == nil || lossRatio(st) > lossRatio(*lossy)) {
+			lossy = &usable[i]
+		}
+	}
+
+	if !p.steered || fast.PathID != p.fast || lossy.PathID != p.lossy {
+		fmt.Printf("[Server] Steering needed pieces to path %v (rtt %v), redundancy to path %v (loss %.3f)\n", fast.PathID, fast.SmoothedRTT, lossy.PathID, lossRatio(*lossy))
+	}
+	p.steered, p.fast, p.lossy = true, fast.PathID, lossy.PathID
+}
+
+// lossRatio is the share of the packets sent on a path which were lost
+func lossRatio(st quic.PathStats) float64 {
+	if st.PacketsSent == 0 {
+		return 0
+	}
+	return float64(st.PacketsLost) / float64(st.PacketsSent)
+}
@@ -0,0 +1,849 @@
+package xnc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/itzmeanjan/kodr"
+	"github.com/itzmeanjan/kodr/full"
+	"github.com/lucas-clemente/quic-go"
+)
+
+// Reader is a file opened on a Conn. Pieces are received and decoded in
+// the background, Read returns the file in order and io.EOF once all of it
+// was read, or an *Error if the transfer failed.
+type Reader struct {
+	conf     *Config
+	sess     quic.Session
+	stream   quic.Stream
+	conn     *pktConn
+	filename string
+	// set from the server's XNC_INFO, only by the receiving goroutine
+	size   int
+	chunks int
+	// digests sent by the server, nil unless conf.Digest is set
+	digests *digestSet
+	// chunks decoded before the request was resumed, and where the ones
+	// decoded now are added, nil if the request doesn't resume
+	state *transferState
+	// servers the pieces come from, the one the file was opened on first
+	// and then its mirrors, set before the transfer starts
+	srcs []*source
+	// frames of every source once mirrors are read, nil otherwise, and
+	// the source of the frame readPkt returned last
+	frames chan sourceFrame
+	from   int
+	// guards the stats of srcs and the ones below
+	statsMutex sync.Mutex
+	// pieces which didn't raise the rank of their decoder
+	dependent int
+	// when the first piece of every chunk arrived, and how long it took
+	// to decode, chunk coding only
+	firstPiece []time.Time
+	latency    []time.Duration
+	// chunk decoders open now and the most open at once
+	decoders    int
+	maxDecoders int
+
+	// decoded data in file order, closed once the transfer is over
+	data chan []byte
+	buf  []byte
+	// why the transfer stopped, only read after data is closed
+	err error
+
+	// closed by stop, stopErr is set before
+	done      chan struct{}
+	stopErr   error
+	finished  chan struct{}
+	closeOnce sync.Once
+
+	start time.Time
+	// bytes received on the wire, bytes handed to Read and transfer time,
+	// updated atomically
+	received  int64
+	delivered int64
+	elapsed   int64
+}
+
+func newReader(c *Conn, stream quic.Stream, conn *pktConn, filename string) *Reader {
+	return &Reader{
+		conf:     c.conf,
+		sess:     c.sess,
+		stream:   stream,
+		conn:     conn,
+		filename: filename,
+		srcs:     []*source{newSource(c.conf.Addr, stream, conn)},
+		data:     make(chan []byte, maxInFlight),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+		start:    time.Now(),
+	}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		data, ok := <-r.data
+		if !ok {
+			if r.err != nil {
+				return 0, r.err
+			}
+			return 0, io.EOF
+		}
+		r.buf = data
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
+
+// Close stops the transfer if it is still running, the server is told to
+// stop sending by resetting the request stream
+func (r *Reader) Close() error {
+	r.stop(errReaderClosed)
+	return nil
+}
+
+// stop ends the transfer with err, unless it is already over
+func (r *Reader) stop(err error) {
+	r.closeOnce.Do(func() {
+		r.stopErr = err
+		close(r.done)
+
+		select {
+		case <-r.finished:
+		default:
+			for _, src := range r.srcs {
+				src.stream.Reset(err)
+				src.conn.Close()
+			}
+			<-r.finished
+		}
+	})
+}
+
+// watch stops the transfer once ctx is done
+func (r *Reader) watch(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		r.stop(ctxError(ctx))
+	case <-r.finished:
+	}
+}
+
+// BytesReceived is the number of bytes received on the wire so far,
+// including redundant coded pieces and headers
+func (r *Reader) BytesReceived() int {
+	return int(atomic.LoadInt64(&r.received))
+}
+
+// Kbps is the throughput on the wire so far, or over the whole transfer
+// once it is over
+func (r *Reader) Kbps() float64 {
+	return kbps(r.BytesReceived(), r.duration())
+}
+
+// duration is the time the transfer took so far, or in all once it is over
+func (r *Reader) duration() time.Duration {
+	elapsed := time.Duration(atomic.LoadInt64(&r.elapsed))
+	if elapsed == 0 {
+		elapsed = time.Since(r.start)
+	}
+	return elapsed
+}
+
+// Stats returns what the transfer received so far, or in all once it is
+// over
+func (r *Reader) Stats() TransferStats {
+	r.statsMutex.Lock()
+	defer r.statsMutex.Unlock()
+
+	stats := TransferStats{
+		Filename:      r.filename,
+		Bytes:         int(atomic.LoadInt64(&r.delivered)),
+		BytesReceived: r.BytesReceived(),
+		Dependent:     r.dependent,
+		ChunkLatency:  append([]time.Duration(nil), r.latency...),
+		Decoders:      r.decoders,
+		MaxDecoders:   r.maxDecoders,
+		Duration:      r.duration(),
+	}
+	for _, src := range r.srcs {
+		stats.Pieces += src.stats.Pieces
+		stats.Innovative += src.stats.Innovative
+	}
+
+	return stats
+}
+
+func (r *Reader) run(initType byte) {
+	defer close(r.finished)
+
+	err := r.readInfo()
+	if err == nil {
+		switch initType {
+		case TYPE_INIT_ENC, TYPE_INIT_SYS:
+			err = r.receiveCoded(initType)
+		case TYPE_INIT_SW:
+			err = r.receiveWindow()
+		default:
+			err = r.receiveRaw()
+		}
+	}
+	atomic.StoreInt64(&r.elapsed, int64(time.Since(r.start)))
+
+	if err != nil && err != r.stopErr {
+		fmt.Printf("[Client] Error receiving %v: %v\n", r.filename, err)
+	}
+
+	r.err = err
+	close(r.data)
+
+	r.stream.Close()
+	r.conn.Close()
+}
+
+// emit hands decoded data to Read, returns false if the reader was closed
+func (r *Reader) emit(data []byte) bool {
+	if r.digests != nil {
+		r.digests.Write(data)
+	}
+
+	select {
+	case r.data <- data:
+		atomic.AddInt64(&r.delivered, int64(len(data)))
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// Sources returns what every server contributed to the transfer so far,
+// the server the file was opened on first
+func (r *Reader) Sources() []SourceStats {
+	r.statsMutex.Lock()
+	defer r.statsMutex.Unlock()
+
+	stats := make([]SourceStats, len(r.srcs))
+	for i, src := range r.srcs {
+		stats[i] = src.stats
+	}
+	return stats
+}
+
+// readInfoFrom reads the XNC_INFO a server answers a request with
+func readInfoFrom(stream quic.Stream) (XNC_INFO, error) {
+	pkt := make([]byte, INFOSIZE)
+	if _, err := io.ReadFull(stream, pkt); err != nil {
+		return XNC_INFO{}, err
+	}
+
+	return DecodeInfo(pkt)
+}
+
+// readInfo waits for the server's answer to the request, which always
+// comes on the stream before any data frame
+func (r *Reader) readInfo() error {
+	pkt := make([]byte, INFOSIZE)
+	if _, err := io.ReadFull(r.stream, pkt); err != nil {
+		return r.readErr(err)
+	}
+	atomic.AddInt64(&r.received, int64(len(pkt)))
+
+	info, err := DecodeInfo(pkt)
+	if err != nil {
+		return newError(ErrDecode, err)
+	}
+
+	if err := statusError(info.Status, r.filename); err != nil {
+		return err
+	}
+
+	chunkSize := r.conf.ChunkSize
+	if info.ChunkNum != (info.FileSize+chunkSize-1)/chunkSize {
+		return newError(ErrDecode, fmt.Errorf("file of %v bytes announced in %v chunks\n", info.FileSize, info.ChunkNum))
+	}
+	r.size = info.FileSize
+	r.chunks = info.ChunkNum
+	if r.conf.Digest {
+		r.digests = newDigestSet(r.chunks)
+	}
+	if r.state != nil {
+		r.state.reset(r.size, r.chunks, info.Version)
+	}
+	fmt.Printf("[Client] File %v has %v bytes in %v chunks\n", r.filename, r.size, r.chunks)
+
+	return nil
+}
+
+// chunkSize is the size of chunk id without its zero padding
+func (r *Reader) chunkSize(id int) int {
+	if remain := r.size - id*r.conf.ChunkSize; remain < r.conf.ChunkSize {
+		return remain
+	}
+	return r.conf.ChunkSize
+}
+
+// readErr is the error returned for a failed read of the request
+func (r *Reader) readErr(err error) error {
+	select {
+	case <-r.done:
+		return r.stopErr
+	default:
+	}
+
+	if isTimeout(err) {
+		return newError(ErrTimeout, err)
+	}
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readPkt returns the next frame, checking that data frames use the chunk
+// size and piece count this request negotiated. DIGEST frames are stored
+// in r.digests and only returned with their type and chunk id.
+func (r *Reader) readPkt() (XNC, error) {
+	pktE, err := r.readFrame()
+	if err != nil {
+		return XNC{}, r.readErr(err)
+	}
+	atomic.AddInt64(&r.received, int64(len(pktE)))
+
+	r.statsMutex.Lock()
+	r.srcs[r.from].stats.Bytes += len(pktE)
+	if pktE[0] != TYPE_DIGEST && pktE[0] != TYPE_END {
+		r.srcs[r.from].stats.Pieces++
+	}
+	r.statsMutex.Unlock()
+
+	if pktE[0] == TYPE_DIGEST {
+		id, digest, err := DecodeDigest(pktE)
+		if err != nil {
+			return XNC{}, newError(ErrDecode, err)
+		}
+		if r.digests == nil {
+			return XNC{}, newError(ErrDecode, fmt.Errorf("unexpected digest of chunk %v\n", id))
+		}
+		if err := r.digests.Add(id, digest); err != nil {
+			return XNC{}, newError(ErrDecode, err)
+		}
+		return XNC{Type: TYPE_DIGEST, ChunkId: id}, nil
+	}
+
+	xncD, err := DecodeXNCPkt(pktE)
+	if err != nil {
+		return XNC{}, newError(ErrDecode, err)
+	}
+
+	if xncD.Type == TYPE_END {
+		fmt.Printf("[Client] Received END packet\n")
+		return xncD, nil
+	}
+
+	if xncD.PieceCount != r.conf.PieceCount || xncD.PieceCount*len(xncD.Piece) != r.conf.ChunkSize {
+		return XNC{}, newError(ErrDecode, fmt.Errorf("packet coded with %v pieces of %v bytes, requested %v pieces of %v bytes\n", xncD.PieceCount, len(xncD.Piece), r.conf.PieceCount, r.conf.PieceSize()))
+	}
+	if xncD.Type != TYPE_XNC_SW && xncD.ChunkNum != r.chunks {
+		return XNC{}, newError(ErrDecode, fmt.Errorf("packet of a file with %v chunks, server announced %v\n", xncD.ChunkNum, r.chunks))
+	}
+	// data is cut to the size the server announced, never to the size a
+	// frame claims
+	if xncD.Type != TYPE_XNC_SW && xncD.ChunkId >= r.chunks {
+		return XNC{}, newError(ErrDecode, fmt.Errorf("chunk %v is out of range\n", xncD.ChunkId))
+	}
+	if xncD.Type != TYPE_XNC_SW && xncD.ChunkSize != r.chunkSize(xncD.ChunkId) {
+		return XNC{}, newError(ErrDecode, fmt.Errorf("packet of chunk %v with %v bytes, expected %v\n", xncD.ChunkId, xncD.ChunkSize, r.chunkSize(xncD.ChunkId)))
+	}
+	if xncD.Type == TYPE_XNC_SW && xncD.ChunkSize != r.size {
+		return XNC{}, newError(ErrDecode, fmt.Errorf("packet of a file with %v bytes, server announced %v\n", xncD.ChunkSize, r.size))
+	}
+
+	return xncD, nil
+}
+
+// readFrame returns the next frame and sets r.from to its source, frames
+// of every source are merged once mirrors are read
+func (r *Reader) readFrame() ([]byte, error) {
+	if r.frames == nil {
+		r.from = 0
+		return r.conn.ReadPkt()
+	}
+
+	frame := <-r.frames
+	r.from = frame.src
+	return frame.pkt, frame.err
+}
+
+// finish checks the whole file against its digest once all of it was
+// emitted, waiting for the digest if it didn't arrive yet
+func (r *Reader) finish() error {
+	if r.digests == nil {
+		return nil
+	}
+
+	for !r.digests.HasFile() {
+		if _, err := r.readPkt(); err != nil {
+			return err
+		}
+	}
+
+	return r.digests.CheckFile()
+}
+
+// receiveCoded decodes every chunk with its own decoder and emits chunks in
+// order, reporting the decoder rank back to the server after every piece.
+// With systematic coding the uncoded pieces at the head of the next chunk
+// are emitted as soon as they arrive, unless the chunk has to be checked
+// against its digest first. Pieces from mirrors go to the same decoders,
+// each server gets the rank after its own pieces and every server hears
+// when a chunk is decoded. Chunks decoded before the request was resumed
+// are emitted in turn like the ones decoded now.
+func (r *Reader) receiveCoded(initType byte) error {
+	// Feedback is written by its own goroutine so reading coded pieces never
+	// waits on the reverse direction of the stream
+	for _, src := range r.srcs {
+		src.startFeedback(r.chunks, r.conf.PieceCount)
+	}
+
+	if len(r.srcs) > 1 {
+		quit := make(chan struct{})
+		defer close(quit)
+
+		r.frames = make(chan sourceFrame)
+		for k := range r.srcs {
+			go r.readSource(k, quit)
+		}
+	}
+
+	// the server interleaves the pieces of several chunks, every chunk gets
+	// a decoder with its first piece which is released once it is decoded.
+	// At most Generations chunks from next are open, decoding or waiting
+	// to be emitted.
+	decoders := make([]ChunkDecoder, r.chunks)
+	decoded := make([]bool, r.chunks)
+	parts := make([][]byte, r.chunks)
+
+	r.statsMutex.Lock()
+	r.firstPiece = make([]time.Time, r.chunks)
+	r.latency = make([]time.Duration, r.chunks)
+	r.statsMutex.Unlock()
+	next := 0
+	// bytes of chunk next already emitted from its uncoded pieces
+	partial := 0
+	// missing chunks were asked for since the last piece, the server
+	// repeats END
+	asked := false
+
+	restored := 0
+	if r.state != nil {
+		for id := range parts {
+			if data := r.state.get(id); data != nil {
+				parts[id] = data
+				decoded[id] = true
+				restored++
+			}
+		}
+	}
+
+	// emits the decoded chunks which are next in order, with digests a
+	// chunk waits until its digest arrived
+	flush := func() error {
+		for next < len(parts) && parts[next] != nil {
+			if r.digests != nil {
+				if !r.digests.Has(next) {
+					return nil
+				}
+				if err := r.digests.CheckChunk(next, parts[next]); err != nil {
+					return err
+				}
+			}
+
+			if r.state != nil {
+				r.state.add(next, parts[next])
+			}
+			if !r.emit(parts[next][partial:]) {
+				return r.stopErr
+			}
+			parts[next] = nil
+			partial = 0
+			next++
+		}
+
+		if next == len(parts) || r.digests != nil {
+			return nil
+		}
+		sys, ok := decoders[next].(*SystematicDecoder)
+		if !ok {
+			return nil
+		}
+
+		size := r.chunkSize(next)
+		for _, piece := range sys.Ready() {
+			if partial+len(piece) > size {
+				piece = piece[:size-partial]
+			}
+			if len(piece) == 0 {
+				break
+			}
+
+			if !r.emit(piece) {
+				return r.stopErr
+			}
+			partial += len(piece)
+		}
+		return nil
+	}
+
+	err := func() error {
+		if restored > 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			if next == len(parts) {
+				fmt.Printf("[Client] Finished decoding file\n")
+				return r.finish()
+			}
+		}
+
+		for {
+			xncD, err := r.readPkt()
+			if err != nil {
+				return err
+			}
+
+			// a mirror ends once it is told every chunk is decoded
+			if xncD.Type == TYPE_END && r.from > 0 {
+				continue
+			}
+			if xncD.Type == TYPE_END {
+				if next == len(decoders) {
+					return r.finish()
+				}
+				if !asked {
+					asked = true
+					r.askMissing(r.srcs[0], next, decoders, decoded)
+				}
+				continue
+			}
+
+			if xncD.Type == TYPE_DIGEST {
+				if err := flush(); err != nil {
+					return err
+				}
+				if next == len(parts) {
+					fmt.Printf("[Client] Finished decoding file\n")
+					return r.finish()
+				}
+				continue
+			}
+
+			// a server which opened the chunk after it was decoded missed
+			// the ack, or doesn't know the request resumed with it, it is
+			// told again
+			if decoded[xncD.ChunkId] {
+				if len(r.srcs) > 1 || restored > 0 {
+					r.srcs[r.from].ack(TYPE_ACK_DECODED, xncD.ChunkId, 0)
+				}
+				continue
+			}
+			// the server only runs ahead while next waits for its digest,
+			// the dropped pieces are repaired once there is room
+			if xncD.ChunkId >= next+r.conf.Generations {
+				continue
+			}
+
+			decoder := decoders[xncD.ChunkId]
+			if decoder == nil {
+				if initType == TYPE_INIT_SYS {
+					decoder = NewSystematicDecoder(uint(r.conf.PieceCount))
+				} else {
+					decoder = full.NewFullRLNCDecoder(uint(r.conf.PieceCount))
+				}
+				decoders[xncD.ChunkId] = decoder
+
+				r.statsMutex.Lock()
+				r.firstPiece[xncD.ChunkId] = time.Now()
+				r.decoders++
+				if r.decoders > r.maxDecoders {
+					r.maxDecoders = r.decoders
+				}
+				r.statsMutex.Unlock()
+			}
+
+			pieceD := &kodr.CodedPiece{
+				Vector: xncD.Vector,
+				Piece:  xncD.Piece,
+			}
+			// seeded transfers send coefficients as a seed and uncoded
+			// pieces as plain frames
+			switch xncD.Type {
+			case TYPE_XNC_SEED:
+				pieceD.Vector = SeedVector(xncD.Seed, xncD.PieceCount)
+			case TYPE_XNC:
+				if xncD.Seq >= xncD.PieceCount {
+					return newError(ErrDecode, fmt.Errorf("uncoded piece %v is out of range\n", xncD.Seq))
+				}
+				pieceD.Vector = make(kodr.CodingVector, xncD.PieceCount)
+				pieceD.Vector[xncD.Seq] = 1
+			}
+
+			asked = false
+			required := decoder.Required()
+			if err := decoder.AddPiece(pieceD); err != nil {
+				if errors.Is(err, kodr.ErrAllUsefulPiecesReceived) {
+					r.statsMutex.Lock()
+					r.dependent++
+					r.statsMutex.Unlock()
+					continue
+				}
+				return newError(ErrDecode, err)
+			}
+
+			src := r.srcs[r.from]
+			src.add(xncD.ChunkId, xncD.Seq)
+			r.statsMutex.Lock()
+			if decoder.Required() < required {
+				src.stats.Innovative++
+			} else {
+				r.dependent++
+			}
+			if decoder.IsDecoded() {
+				r.latency[xncD.ChunkId] = time.Since(r.firstPiece[xncD.ChunkId])
+			}
+			r.statsMutex.Unlock()
+
+			// Report the decoder rank so the server knows how many more pieces to send
+			if decoder.IsDecoded() {
+				for _, s := range r.srcs {
+					s.ack(TYPE_ACK_DECODED, xncD.ChunkId, 0)
+				}
+			} else {
+				src.ack(TYPE_ACK_RANK, xncD.ChunkId, int(decoder.Required()))
+			}
+
+			if decoder.IsDecoded() {
+				recvfile, err := GetFile(decoder)
+				if err != nil {
+					return newError(ErrDecode, err)
+				}
+				parts[xncD.ChunkId] = recvfile[:r.chunkSize(xncD.ChunkId)]
+				decoded[xncD.ChunkId] = true
+				decoders[xncD.ChunkId] = nil
+
+				r.statsMutex.Lock()
+				r.decoders--
+				r.statsMutex.Unlock()
+			} else if xncD.ChunkId != next {
+				continue
+			}
+
+			if err := flush(); err != nil {
+				return err
+			}
+
+			if next == len(parts) {
+				fmt.Printf("[Client] Finished decoding file\n")
+				return r.finish()
+			}
+		}
+	}()
+
+	for k, src := range r.srcs {
+		if err != nil {
+			src.acks <- XNC_ACK{Type: TYPE_ACK_ABORT, ChunkId: next}
+		}
+		src.stopFeedback()
+
+		// the first source is closed when the transfer is over
+		if k > 0 {
+			src.stream.Close()
+			src.conn.Close()
+		}
+	}
+
+	return err
+}
+
+// askMissing requests the chunks which aren't decoded when the server
+// already sent END, from next up to the generations the client keeps open.
+// The server codes a chunk it believes decoded again.
+func (r *Reader) askMissing(src *source, next int, decoders []ChunkDecoder, decoded []bool) {
+	missing := 0
+	for id := next; id < len(decoders) && id < next+r.conf.Generations; id++ {
+		if decoded[id] {
+			continue
+		}
+
+		required := r.conf.PieceCount
+		if decoders[id] != nil {
+			required = int(decoders[id].Required())
+		}
+		src.ack(TYPE_ACK_MORE, id, required)
+		missing++
+	}
+
+	if missing > 0 {
+		fmt.Printf("[Client] Transfer ended with %v chunks missing, asking for them again\n", missing)
+	}
+}
+
+// receiveRaw emits every chunk once all of its uncoded pieces arrived
+func (r *Reader) receiveRaw() error {
+	chunk := make([]byte, 0, r.conf.ChunkSize)
+	next := 0
+
+	for {
+		xncD, err := r.readPkt()
+		if err != nil {
+			return err
+		}
+
+		if xncD.Type == TYPE_END {
+			if next < r.chunks {
+				return newError(ErrDecode, fmt.Errorf("transfer ended with %v of %v chunks received\n", next, r.chunks))
+			}
+			return r.finish()
+		}
+		if xncD.Type == TYPE_DIGEST {
+			continue
+		}
+		if xncD.ChunkId != next {
+			return newError(ErrDecode, fmt.Errorf("received chunk %v, expected %v\n", xncD.ChunkId, next))
+		}
+
+		// uncoded pieces are all innovative
+		r.statsMutex.Lock()
+		r.srcs[0].stats.Innovative++
+		r.statsMutex.Unlock()
+
+		chunk = append(chunk, xncD.Piece...)
+		if len(chunk) < r.conf.ChunkSize {
+			continue
+		}
+
+		// digests come on the stream ahead of the pieces of their chunk
+		if r.digests != nil {
+			if !r.digests.Has(next) {
+				return newError(ErrDecode, fmt.Errorf("chunk %v arrived without its digest\n", next))
+			}
+			if err := r.digests.CheckChunk(next, chunk[:r.chunkSize(next)]); err != nil {
+				return err
+			}
+		}
+
+		if !r.emit(chunk[:r.chunkSize(next)]) {
+			return r.stopErr
+		}
+		chunk = make([]byte, 0, r.conf.ChunkSize)
+		next++
+
+		if next == r.chunks {
+			fmt.Printf("[Client] Finished decoding file\n")
+			return r.finish()
+		}
+	}
+}
+
+// receiveWindow emits pieces as soon as the sliding window decoder can
+// deliver them in order, acknowledging the next piece it needs
+func (r *Reader) receiveWindow() error {
+	acks := make(chan XNC_ACK, maxInFlight*(r.conf.PieceCount+1))
+	acksDone := make(chan struct{})
+	go writeACKs(r.stream, acks, acksDone)
+
+	var decoder *SlidingWindowDecoder
+	delivered := 0
+
+	err := func() error {
+		for {
+			xncD, err := r.readPkt()
+			if err != nil {
+				return err
+			}
+
+			if xncD.Type == TYPE_END {
+				if delivered < r.size {
+					return newError(ErrDecode, fmt.Errorf("transfer ended with %v of %v bytes delivered\n", delivered, r.size))
+				}
+				return r.finish()
+			}
+			if xncD.Type == TYPE_DIGEST {
+				continue
+			}
+
+			if decoder == nil {
+				pieceSize := len(xncD.Piece)
+				pieceCount := (r.size + pieceSize - 1) / pieceSize
+				decoder = NewSlidingWindowDecoder(uint(pieceCount), uint(xncD.PieceCount))
+			}
+
+			rank := decoder.rank()
+			pieces, err := decoder.AddPiece(uint(xncD.ChunkId), uint(xncD.ChunkNum), &kodr.CodedPiece{
+				Vector: xncD.Vector,
+				Piece:  xncD.Piece,
+			})
+			if err != nil {
+				if errors.Is(err, kodr.ErrAllUsefulPiecesReceived) {
+					continue
+				}
+				return newError(ErrDecode, err)
+			}
+
+			r.statsMutex.Lock()
+			if decoder.rank() > rank {
+				r.srcs[0].stats.Innovative++
+			} else {
+				r.dependent++
+			}
+			r.statsMutex.Unlock()
+
+			for _, piece := range pieces {
+				if delivered+len(piece) > r.size {
+					piece = piece[:r.size-delivered]
+				}
+				delivered += len(piece)
+
+				if !r.emit(piece) {
+					return r.stopErr
+				}
+			}
+
+			ack := XNC_ACK{
+				Type:     TYPE_ACK_RANK,
+				ChunkId:  int(decoder.Next()),
+				Required: int(decoder.Required()),
+				Received: int(decoder.GetRecv()),
+				Seq:      xncD.Seq,
+			}
+			if decoder.IsDecoded() {
+				ack.Type = TYPE_ACK_DECODED
+			}
+			acks <- ack
+
+			if decoder.IsDecoded() {
+				fmt.Printf("[Client] Finished decoding file\n")
+				return r.finish()
+			}
+		}
+	}()
+
+	if err != nil {
+		next := 0
+		if decoder != nil {
+			next = int(decoder.Next())
+		}
+		acks <- XNC_ACK{Type: TYPE_ACK_ABORT, ChunkId: next}
+	}
+	close(acks)
+	<-acksDone
+
+	return err
+}
@@ -0,0 +1,139 @@
+package xnc
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// weight of the newest sample in the loss rate EWMA
+	lossAlpha float64 = 0.25
+	// never assume more loss than this, keeps the redundancy bounded
+	maxLossRate float64 = 0.5
+	// chunks allowed to be sent but not yet decoded by the client
+	maxInFlight int = 8
+	// added on top of 2*RTT before a silent chunk is repaired
+	repairSlack time.Duration = 20 * time.Millisecond
+	// times the wait before repairing a silent chunk is doubled at most
+	maxRepairBackoff int = 4
+	// pieces sent after the last one of a chunk the client has to report
+	// before the missing pieces of the chunk are taken for lost, allows
+	// for reordering
+	reorderThreshold int = 3
+	// how long the server waits after END for a client missing chunks
+	endLinger time.Duration = time.Second
+	// how often the server rereads the path stats of a multipath session
+	steerInterval time.Duration = 100 * time.Millisecond
+	// extra pieces per burst until the first loss sample comes in
+	defaultRedundancy uint = 1
+)
+
+// lossEstimator tracks the piece loss rate seen by the client and turns it
+// into the number of extra coded pieces sent along with each burst.
+type lossEstimator struct {
+	rate    float64
+	samples int
+}
+
+func newLossEstimator() *lossEstimator {
+	return &lossEstimator{}
+}
+
+// Update feeds one finished chunk into the estimator: sent is the number
+// of pieces the server had sent up to the last one the client saw, received
+// the number of those that made it.
+func (l *lossEstimator) Update(sent int, received int) {
+	if sent <= 0 {
+		return
+	}
+
+	sample := 1 - float64(received)/float64(sent)
+	if sample < 0 {
+		sample = 0
+	}
+
+	if l.samples == 0 {
+		l.rate = sample
+	} else {
+		l.rate = lossAlpha*sample + (1-lossAlpha)*l.rate
+	}
+	l.samples++
+}
+
+func (l *lossEstimator) Rate() float64 {
+	return l.rate
+}
+
+// Redundancy returns how many pieces to send on top of required so that,
+// at the current loss rate, required of them are expected to arrive.
+// Without any sample yet it falls back to defaultRedundancy.
+func (l *lossEstimator) Redundancy(required uint) uint {
+	if l.samples == 0 {
+		return defaultRedundancy
+	}
+
+	rate := math.Min(l.rate, maxLossRate)
+	return uint(math.Ceil(float64(required) * rate / (1 - rate)))
+}
+
+func repairTimeout(rtt time.Duration) time.Duration {
+	return 2*rtt + repairSlack
+}
+
+// repairTrack follows the pieces sent of one chunk, to tell when the ones
+// the client didn't report were lost
+type repairTrack struct {
+	lastSent time.Time
+	// place of every piece sent in the order of all pieces of the
+	// request, by sequence number
+	orders []int
+	// repairs sent since the client last reported on the chunk, each one
+	// doubles the time before the next
+	silent int
+}
+
+// sentAt records a piece of the chunk sent as the order-th of the request
+func (t *repairTrack) sentAt(order int) {
+	t.lastSent = time.Now()
+	t.orders = append(t.orders, order)
+}
+
+// due tells whether the chunk is repaired now, and whether with a single
+// probe piece. heard is the place of the last piece the client reported,
+// lastHeard when it last gave feedback. Pieces can wait in the datagram
+// queue for longer than the repair timeout, so the chunk is only taken for
+// lost once the client saw its last piece (caughtUp), reported pieces sent
+// well after it, or went silent. Feedback also stops while lost acks are
+// retransmitted, so with the client silent only the first chunk it misses
+// is repaired, backing off, and the others once it reports again.
+func (t *repairTrack) due(caughtUp bool, first bool, heard int, lastHeard time.Time, timeout time.Duration) (repair bool, probe bool) {
+	if caughtUp {
+		return true, false
+	}
+	if len(t.orders) > 0 && heard >= t.orders[len(t.orders)-1]+reorderThreshold {
+		return true, false
+	}
+
+	wait := timeout << t.silent
+	if !first || time.Since(t.lastSent) < wait || time.Since(lastHeard) < wait {
+		return false, false
+	}
+
+	// a client still silent after a repair may only miss the feedback
+	// path, it is probed with one piece at a time
+	probe = t.silent > 0
+	if t.silent < maxRepairBackoff {
+		t.silent++
+	}
+	return true, probe
+}
+
+// report records feedback on the chunk for the piece of sequence number
+// seq, and returns the later of heard and that piece's place
+func (t *repairTrack) report(seq int, heard int) int {
+	t.silent = 0
+	if seq >= 0 && seq < len(t.orders) && t.orders[seq] > heard {
+		return t.orders[seq]
+	}
+	return heard
+}
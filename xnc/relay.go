@@ -0,0 +1,747 @@
+package xnc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/itzmeanjan/kodr"
+	"github.com/itzmeanjan/kodr/full"
+	"github.com/lucas-clemente/quic-go"
+)
+
+// Relay sits between xnc clients and an upstream xnc server and forwards
+// coded chunks without decoding them. A request is fetched from upstream
+// once, however many clients ask for the same file with the same coding
+// parameters at the same time, and every client is sent freshly recoded
+// pieces of what the relay buffered so far. Only chunk coding with coding
+// vectors can be recoded, other requests are refused.
+type Relay struct {
+	conf     *Config
+	upstream *Client
+
+	mutex sync.Mutex
+	// running fetches by request, without the flags of a single client
+	fetches map[XNC_INIT]*relayFetch
+}
+
+// NewRelay copies conf, which holds the address and TLS settings clients
+// see, and fetches from the server at upstream.Addr with the transport
+// settings of upstream. Unset fields of both are taken from DefaultConfig.
+func NewRelay(conf *Config, upstream *Config) (*Relay, error) {
+	conf = conf.withDefaults()
+
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	if conf.TLSConfig == nil {
+		conf.TLSConfig = GenerateTLSConfig()
+		if conf.TLSConfig == nil {
+			return nil, fmt.Errorf("relay has no TLS config\n")
+		}
+	}
+
+	client, err := NewClient(upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Relay{conf: conf, upstream: client, fetches: make(map[XNC_INIT]*relayFetch)}, nil
+}
+
+func (r *Relay) ListenAndServe(ctx context.Context) error {
+	quicConf := &quic.Config{}
+
+	listener, err := quic.ListenAddr(r.conf.Addr, r.conf.TLSConfig, quicConf)
+	if err != nil {
+		fmt.Println("[Relay] Failed to start relay:", err)
+		return err
+	}
+	defer listener.Close()
+
+	return r.Serve(ctx, listener)
+}
+
+// Serve serves the sessions of listener until ctx is done or listener is
+// closed, the listener is left to the caller
+func (r *Relay) Serve(ctx context.Context, listener quic.Listener) error {
+	defer r.upstream.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("[Relay] Relay shutting down")
+			return nil
+		default:
+			sess, err := listener.Accept()
+			if err != nil {
+				fmt.Println("[Relay] Failed to accept session:", err)
+				return err
+			}
+
+			go r.handleSession(ctx, sess)
+		}
+	}
+}
+
+func (r *Relay) handleSession(ctx context.Context, sess quic.Session) {
+	for {
+		stream, err := sess.AcceptStream()
+		if err != nil {
+			if err != io.EOF {
+				fmt.Printf("[Relay] Error accepting stream: %v\n", err)
+			}
+			return
+		}
+
+		go func() {
+			init, conf, ok := readRequest(stream, r.conf)
+			if !ok {
+				stream.Close()
+				return
+			}
+
+			if init.Type != TYPE_INIT_ENC || conf.Seed {
+				fmt.Printf("[Relay] Only chunks coded with coding vectors can be recoded\n")
+				sendStatus(stream, STATUS_BAD_REQUEST)
+				stream.Close()
+				return
+			}
+
+			r.serve(ctx, sess, stream, conf, init)
+
+			// lets the client's Reader see the end of a request which failed
+			stream.Close()
+		}()
+	}
+}
+
+// serve answers one client request from the fetch of its file
+func (r *Relay) serve(ctx context.Context, sess quic.Session, stream quic.Stream, conf *Config, init XNC_INIT) {
+	f := r.join(ctx, init)
+	defer r.leave(f)
+
+	<-f.ready
+	var resumed []bool
+	if init.Flags&INITFLAG_RESUME != 0 && f.info.Status == STATUS_OK {
+		var err error
+		resumed, err = readResume(stream, f.info)
+		if err != nil {
+			fmt.Printf("[Relay] Error reading resume request: %v\n", err)
+			sendStatus(stream, STATUS_BAD_REQUEST)
+			return
+		}
+	}
+
+	if err := sendInfo(stream, f.info); err != nil {
+		fmt.Printf("[Relay] Error sending file info: %v\n", err)
+		return
+	}
+	if f.info.Status != STATUS_OK {
+		return
+	}
+
+	if err := relayCoded(sess, stream, newPktConn(sess, stream, conf.Datagram), conf, f, resumed); err != nil {
+		fmt.Printf("[Relay] Error relaying %v: %v\n", init.Filename, err)
+		return
+	}
+	fmt.Printf("[Relay] Finished relaying %v\n", init.Filename)
+}
+
+// join returns the running fetch of init, starting one if there is none
+func (r *Relay) join(ctx context.Context, init XNC_INIT) *relayFetch {
+	// clients asking for the same chunks share a fetch whatever their
+	// transport, digests are part of the request
+	key := init
+	key.Flags &= INITFLAG_DIGEST
+	key.Window = 0
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	f, ok := r.fetches[key]
+	if !ok {
+		f = newRelayFetch(key)
+		r.fetches[key] = f
+		go f.run(ctx, r.upstream)
+	}
+	f.users++
+
+	return f
+}
+
+// leave stops a fetch once its last client is done with it
+func (r *Relay) leave(f *relayFetch) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	f.users--
+	if f.users == 0 {
+		delete(r.fetches, f.init)
+		f.stop()
+	}
+}
+
+// relayFetch is one request to the upstream server. Every innovative piece
+// of a chunk is buffered as it arrives, the chunks are never decoded.
+type relayFetch struct {
+	init XNC_INIT
+	// clients of the relay using the fetch, guarded by Relay.mutex
+	users int
+
+	// closed once info is set
+	ready chan struct{}
+	info  XNC_INFO
+	// closed by stop
+	quit     chan struct{}
+	stopOnce sync.Once
+
+	mutex sync.Mutex
+	gens  []*relayGeneration
+	// DIGEST frames from the server, forwarded as they are
+	digests [][]byte
+	// set once every chunk is complete, or the fetch failed with err
+	complete bool
+	err      error
+	// closed and replaced whenever anything above changes
+	changed chan struct{}
+}
+
+// relayGeneration buffers the innovative pieces of one chunk. rank runs
+// Gaussian elimination on copies only to tell which pieces are innovative,
+// the buffered pieces are recoded as they came.
+type relayGeneration struct {
+	size    int
+	rank    *full.FullRLNCDecoder
+	pieces  []*kodr.CodedPiece
+	recoder *full.FullRLNCRecoder
+}
+
+func newRelayFetch(init XNC_INIT) *relayFetch {
+	return &relayFetch{
+		init:    init,
+		ready:   make(chan struct{}),
+		quit:    make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+}
+
+// stop ends the fetch if it is still running
+func (f *relayFetch) stop() {
+	f.stopOnce.Do(func() {
+		close(f.quit)
+	})
+}
+
+// notify wakes up everyone waiting on changed, with f.mutex held
+func (f *relayFetch) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// watch returns a channel which is closed on the next change
+func (f *relayFetch) watch() <-chan struct{} {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.changed
+}
+
+// done reports whether the fetch is over, err is set if it failed
+func (f *relayFetch) done() (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.complete || f.err != nil, f.err
+}
+
+// rank is the number of innovative pieces buffered of chunk id
+func (f *relayFetch) rank(id int) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.gens[id] == nil {
+		return 0
+	}
+	return len(f.gens[id].pieces)
+}
+
+// digestFrames returns the DIGEST frames following the first from
+func (f *relayFetch) digestFrames(from int) [][]byte {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.digests[from:]
+}
+
+// recode returns a fresh combination of the buffered pieces of chunk id,
+// along with the size of the chunk
+func (f *relayFetch) recode(id int) (*kodr.CodedPiece, int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	gen := f.gens[id]
+	if gen == nil || len(gen.pieces) == 0 {
+		return nil, 0, fmt.Errorf("no pieces of chunk %v to recode\n", id)
+	}
+	if gen.recoder == nil {
+		gen.recoder = full.NewFullRLNCRecoder(gen.pieces)
+	}
+
+	piece, err := gen.recoder.CodedPiece()
+	return piece, gen.size, err
+}
+
+// add buffers xncD if it is innovative and returns the feedback for the
+// server, ok is false for a piece of a chunk which is already complete
+func (f *relayFetch) add(xncD XNC) (ack XNC_ACK, ok bool, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	gen := f.gens[xncD.ChunkId]
+	if gen == nil {
+		gen = &relayGeneration{size: xncD.ChunkSize, rank: full.NewFullRLNCDecoder(uint(xncD.PieceCount))}
+		f.gens[xncD.ChunkId] = gen
+	}
+	if gen.rank == nil {
+		return XNC_ACK{}, false, nil
+	}
+
+	// the decoder reduces its pieces in place, the buffer keeps the
+	// originals
+	copied := &kodr.CodedPiece{
+		Vector: append(kodr.CodingVector(nil), xncD.Vector...),
+		Piece:  append(kodr.Piece(nil), xncD.Piece...),
+	}
+	required := gen.rank.Required()
+	if err := gen.rank.AddPiece(copied); err != nil {
+		return XNC_ACK{}, false, err
+	}
+	if gen.rank.Required() < required {
+		gen.pieces = append(gen.pieces, &kodr.CodedPiece{Vector: xncD.Vector, Piece: xncD.Piece})
+		gen.recoder = nil
+		f.notify()
+	}
+
+	ack = XNC_ACK{
+		Type:     TYPE_ACK_RANK,
+		ChunkId:  xncD.ChunkId,
+		Required: int(gen.rank.Required()),
+		Received: int(gen.rank.GetRecv()),
+		Seq:      xncD.Seq,
+	}
+	if gen.rank.IsDecoded() {
+		ack.Type = TYPE_ACK_DECODED
+		gen.rank = nil
+	}
+
+	return ack, true, nil
+}
+
+func (f *relayFetch) run(ctx context.Context, upstream *Client) {
+	err := f.fetch(ctx, upstream)
+	if err != nil {
+		fmt.Printf("[Relay] Error fetching %v: %v\n", f.init.Filename, err)
+	}
+
+	f.mutex.Lock()
+	f.err = err
+	f.complete = err == nil
+	f.notify()
+	f.mutex.Unlock()
+
+	// the clients waiting for the file are told it can't be served
+	select {
+	case <-f.ready:
+	default:
+		f.info = XNC_INFO{Type: TYPE_INFO, Status: STATUS_INTERNAL}
+		close(f.ready)
+	}
+}
+
+// fetch requests the file from upstream and buffers its pieces until every
+// chunk is complete, reporting the rank of each back like a client does
+func (f *relayFetch) fetch(ctx context.Context, upstream *Client) error {
+	conn, err := upstream.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("[Relay] Fetching %v from %v\n", f.init.Filename, upstream.conf.Addr)
+
+	init := f.init
+	init.Window = upstream.conf.Generations
+	stream, pc, err := conn.request(init, nil)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	defer pc.Close()
+
+	// stopping resets the stream, which ends the reads below
+	fetched := make(chan struct{})
+	defer close(fetched)
+	go func() {
+		select {
+		case <-f.quit:
+			stream.Reset(errFetchStopped)
+			pc.Close()
+		case <-fetched:
+		}
+	}()
+
+	pkt := make([]byte, INFOSIZE)
+	if _, err := io.ReadFull(stream, pkt); err != nil {
+		return err
+	}
+	info, err := DecodeInfo(pkt)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	f.info = info
+	f.gens = make([]*relayGeneration, info.ChunkNum)
+	f.mutex.Unlock()
+	close(f.ready)
+
+	if info.Status != STATUS_OK {
+		return nil
+	}
+
+	acks := make(chan XNC_ACK, maxInFlight*(init.PieceCount+1))
+	acksDone := make(chan struct{})
+	go writeACKs(stream, acks, acksDone)
+	defer func() {
+		close(acks)
+		<-acksDone
+	}()
+
+	complete := make([]bool, info.ChunkNum)
+	remaining := info.ChunkNum
+	// the digest of the whole file comes last, with the last chunk
+	hasFile := init.Flags&INITFLAG_DIGEST == 0
+	asked := false
+
+	for remaining > 0 || !hasFile {
+		pktE, err := pc.ReadPkt()
+		if err != nil {
+			return err
+		}
+
+		if pktE[0] == TYPE_DIGEST {
+			id, _, err := DecodeDigest(pktE)
+			if err != nil {
+				return err
+			}
+			hasFile = hasFile || id == info.ChunkNum
+
+			f.mutex.Lock()
+			f.digests = append(f.digests, pktE)
+			f.notify()
+			f.mutex.Unlock()
+			continue
+		}
+
+		xncD, err := DecodeXNCPkt(pktE)
+		if err != nil {
+			return err
+		}
+
+		// the server believes every chunk is complete, ask again for the
+		// missing ones
+		if xncD.Type == TYPE_END {
+			if !asked {
+				asked = true
+				for id := range complete {
+					if !complete[id] {
+						acks <- XNC_ACK{Type: TYPE_ACK_MORE, ChunkId: id, Required: init.PieceCount}
+					}
+				}
+			}
+			continue
+		}
+
+		if xncD.Type != TYPE_XNC_ENC || xncD.ChunkId >= info.ChunkNum || xncD.PieceCount != init.PieceCount || xncD.PieceCount*len(xncD.Piece) != init.ChunkSize {
+			return fmt.Errorf("unexpected frame of type %v for chunk %v\n", xncD.Type, xncD.ChunkId)
+		}
+		asked = false
+
+		ack, ok, err := f.add(xncD)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		acks <- ack
+
+		if ack.Type == TYPE_ACK_DECODED {
+			complete[xncD.ChunkId] = true
+			remaining--
+		}
+	}
+
+	fmt.Printf("[Relay] Fetched %v\n", f.init.Filename)
+	return nil
+}
+
+// relayState is the relay's view of one chunk sent to one client
+type relayState struct {
+	sent int
+	// buffered pieces already forwarded
+	forwarded int
+	acked     bool
+	required  int
+	received  int
+	seq       int
+	decoded   bool
+	repairTrack
+}
+
+// relayCoded sends the chunks of f to one client. Every innovative piece
+// the relay buffers is forwarded right away as a fresh recoded piece, and
+// the client's feedback is answered like sendCoded does as far as the
+// buffered pieces allow. Chunks are only forwarded Generations ahead of
+// the first one the client hasn't decoded. Chunks set in resumed are only
+// sent if the client asks for them again.
+func relayCoded(sess quic.Session, stream quic.Stream, conn *pktConn, conf *Config, f *relayFetch, resumed []bool) error {
+	pieceCount := conf.PieceCount
+
+	feedback := make(chan XNC_ACK, maxInFlight*conf.PieceCount)
+	done := make(chan struct{})
+	defer close(done)
+
+	go readACKs(stream, feedback, done)
+
+	est := newLossEstimator()
+	chunkNum := f.info.ChunkNum
+	states := make([]relayState, chunkNum)
+	first, decoded, digests := 0, 0, 0
+	for i := range resumed {
+		if resumed[i] {
+			states[i].decoded = true
+			decoded++
+		}
+	}
+	for first < chunkNum && states[first].decoded {
+		first++
+	}
+	// pieces sent so far, the place of the last piece the client reported
+	// and when it last gave feedback
+	order, heard := 0, -1
+	lastHeard := time.Now()
+
+	sendOne := func(i int) error {
+		st := &states[i]
+
+		piece, size, err := f.recode(i)
+		if err != nil {
+			return err
+		}
+		pktE, err := GetXNCEncPkt(size, i, chunkNum, st.sent, piece)
+		if err != nil {
+			return err
+		}
+
+		if err := conn.WritePkt(pktE); err != nil {
+			return err
+		}
+		st.sent++
+		st.sentAt(order)
+		order++
+
+		return nil
+	}
+
+	// a burst covers what the buffered pieces can add to the client's
+	// rank of chunk i, plus the estimator's redundancy
+	burstOf := func(i int, required int) burst {
+		pieces := f.rank(i) - (pieceCount - required)
+		if pieces <= 0 {
+			return burst{chunk: i}
+		}
+		return burst{chunk: i, pieces: pieces + int(est.Redundancy(uint(pieces)))}
+	}
+
+	// forward sends new digests, and a recoded piece of every chunk for
+	// each innovative piece buffered since the last call
+	forward := func() error {
+		for _, pkt := range f.digestFrames(digests) {
+			if err := conn.WriteStreamPkt(pkt); err != nil {
+				return err
+			}
+			digests++
+		}
+
+		var bursts []burst
+		for i := first; i < chunkNum && i < first+conf.Generations; i++ {
+			st := &states[i]
+			if st.decoded {
+				continue
+			}
+
+			rank := f.rank(i)
+			pieces := rank - st.forwarded
+			if rank == pieceCount && st.forwarded < pieceCount {
+				pieces += int(est.Redundancy(uint(pieceCount)))
+			}
+			st.forwarded = rank
+
+			if pieces > 0 {
+				bursts = append(bursts, burst{chunk: i, pieces: pieces})
+			}
+		}
+
+		return interleave(bursts, sendOne)
+	}
+
+	apply := func(ack XNC_ACK) error {
+		if ack.Type == TYPE_ACK_ABORT {
+			return fmt.Errorf("transfer aborted by client at chunk %v", ack.ChunkId)
+		}
+
+		if ack.ChunkId < 0 || ack.ChunkId >= chunkNum {
+			return nil
+		}
+
+		st := &states[ack.ChunkId]
+		lastHeard = time.Now()
+		heard = st.report(ack.Seq, heard)
+		if st.decoded {
+			if ack.Type != TYPE_ACK_MORE {
+				return nil
+			}
+			// the relay still holds the chunk, it is only sent again
+			st.decoded = false
+			decoded--
+			if ack.ChunkId < first {
+				first = ack.ChunkId
+			}
+		}
+
+		if ack.Seq > st.seq {
+			st.seq = ack.Seq
+		}
+
+		switch ack.Type {
+		case TYPE_ACK_RANK:
+			st.acked = true
+			st.required = ack.Required
+			st.received = ack.Received
+
+		case TYPE_ACK_DECODED:
+			st.decoded = true
+			st.received = ack.Received
+			decoded++
+			est.Update(st.seq+1, st.received)
+			for first < chunkNum && states[first].decoded {
+				first++
+			}
+
+		case TYPE_ACK_MORE:
+			st.acked = true
+			st.required = ack.Required
+			return interleave([]burst{burstOf(ack.ChunkId, ack.Required)}, sendOne)
+		}
+
+		return nil
+	}
+
+	for {
+		changed := f.watch()
+		over, err := f.done()
+		if err != nil {
+			return err
+		}
+
+		if err := forward(); err != nil {
+			return err
+		}
+
+	DRAIN:
+		for decoded < chunkNum {
+			select {
+			case ack, ok := <-feedback:
+				if !ok {
+					return fmt.Errorf("client stopped sending feedback")
+				}
+				if err := apply(ack); err != nil {
+					return err
+				}
+			default:
+				break DRAIN
+			}
+		}
+
+		// END waits for the fetch, the client may still need digests
+		if decoded == chunkNum && over {
+			sendEnd(conn, chunkNum-1)
+
+			linger := time.After(endLinger)
+			for decoded == chunkNum {
+				select {
+				case ack, ok := <-feedback:
+					if !ok {
+						return nil
+					}
+					if err := apply(ack); err != nil {
+						return err
+					}
+				case <-linger:
+					return nil
+				}
+			}
+			continue
+		}
+
+		timeout := repairTimeout(sess.GetRtt())
+		var repairs []burst
+		for i := first; i < chunkNum && i < first+conf.Generations; i++ {
+			st := &states[i]
+			if st.decoded || st.sent == 0 {
+				continue
+			}
+
+			caughtUp := st.acked && st.seq+1 >= st.sent
+			repair, probe := st.due(caughtUp, i == first, heard, lastHeard, timeout)
+			if !repair {
+				continue
+			}
+
+			required := pieceCount
+			if st.acked {
+				required = st.required
+			}
+			b := burstOf(i, required)
+			if probe && b.pieces > 1 {
+				b.pieces = 1
+			}
+			if b.pieces > 0 {
+				repairs = append(repairs, b)
+			}
+		}
+		if err := interleave(repairs, sendOne); err != nil {
+			return err
+		}
+
+		select {
+		case ack, ok := <-feedback:
+			// a client may decode everything before the fetch is over
+			if !ok && decoded == chunkNum {
+				return nil
+			}
+			if !ok {
+				return fmt.Errorf("client stopped sending feedback")
+			}
+			if err := apply(ack); err != nil {
+				return err
+			}
+		case <-changed:
+		case <-time.After(timeout):
+		}
+	}
+}
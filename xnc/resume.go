@@ -0,0 +1,207 @@
+package xnc
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// times a Client resumes one request after its session dropped before
+// the error is returned
+const maxResumes int = 3
+
+// how long a failed request waits for its session to close before the
+// failure is taken for one of the request alone
+const resumeWait time.Duration = 500 * time.Millisecond
+
+// transferState keeps the chunks a Client decoded of one request, so the
+// request resumes with the others once its session dropped. With a
+// directory the chunks are also appended to a file there and a later
+// Client picks them up. Chunks still being decoded are requested again.
+type transferState struct {
+	mutex sync.Mutex
+	// file the chunks are kept in, only kept in memory if empty
+	path string
+	// of the file as the server last answered, chunkNum is 0 before
+	size     int
+	chunkNum int
+	version  int64
+	chunks   map[int][]byte
+}
+
+// newTransferState returns the state of the request named by key, loaded
+// from dir if the chunks of an earlier Client are there
+func newTransferState(dir string, key string) *transferState {
+	t := &transferState{chunks: make(map[int][]byte)}
+	if dir == "" {
+		return t
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Printf("[Client] Error creating resume directory %v: %v\n", dir, err)
+		return t
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	t.path = filepath.Join(dir, hex.EncodeToString(sum[:16])+".xnc")
+	if err := t.load(); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("[Client] Error loading resume state %v: %v\n", t.path, err)
+	}
+
+	return t
+}
+
+// resumeKey names a request of a Client, every request with the same key
+// splits the file into the same chunks
+func resumeKey(conf *Config, filename string, offset int64, length int64) string {
+	return fmt.Sprintf("%v %v %v %v %v", conf.Addr, cleanName(filename), offset, length, conf.ChunkSize)
+}
+
+// load reads the file written by reset and add, a record cut short by a
+// crash is left out
+func (t *transferState) load() error {
+	file, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	header := make([]byte, OFFSETSIZE+NUMSIZE+VERSIONSIZE)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	t.size = int(binary.BigEndian.Uint64(header[0:8]))
+	t.chunkNum = int(binary.BigEndian.Uint32(header[8:12]))
+	t.version = int64(binary.BigEndian.Uint64(header[12:20]))
+
+	for {
+		record := make([]byte, IDSIZE+4)
+		if _, err := io.ReadFull(r, record); err != nil {
+			break
+		}
+		id := int(binary.BigEndian.Uint32(record[0:4]))
+		n := int(binary.BigEndian.Uint32(record[4:8]))
+		if id >= t.chunkNum || n > MAXCHUNKSIZE {
+			break
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			break
+		}
+		t.chunks[id] = data
+	}
+
+	if len(t.chunks) > 0 {
+		fmt.Printf("[Client] Loaded %v of %v chunks from %v\n", len(t.chunks), t.chunkNum, t.path)
+	}
+	return nil
+}
+
+// resume is the resume frame of the next request, nil if no chunk was
+// decoded yet
+func (t *transferState) resume() *XNC_RESUME {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.chunks) == 0 {
+		return nil
+	}
+
+	done := make([]bool, t.chunkNum)
+	for id := range t.chunks {
+		done[id] = true
+	}
+	return &XNC_RESUME{Type: TYPE_RESUME, FileSize: t.size, ChunkNum: t.chunkNum, Version: t.version, Done: done}
+}
+
+// reset drops the chunks if the server answered with another size, chunk
+// count or version of the file than they were decoded for
+func (t *transferState) reset(size int, chunkNum int, version int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.size == size && t.chunkNum == chunkNum && t.version == version {
+		return
+	}
+	if len(t.chunks) > 0 {
+		fmt.Printf("[Client] File changed since the transfer was interrupted, dropping %v chunks\n", len(t.chunks))
+	}
+
+	t.size, t.chunkNum, t.version = size, chunkNum, version
+	t.chunks = make(map[int][]byte)
+
+	if t.path == "" {
+		return
+	}
+	header := make([]byte, OFFSETSIZE+NUMSIZE+VERSIONSIZE)
+	binary.BigEndian.PutUint64(header[0:8], uint64(size))
+	binary.BigEndian.PutUint32(header[8:12], uint32(chunkNum))
+	binary.BigEndian.PutUint64(header[12:20], uint64(version))
+	if err := os.WriteFile(t.path, header, 0644); err != nil {
+		fmt.Printf("[Client] Error writing resume state %v: %v\n", t.path, err)
+	}
+}
+
+// add keeps chunk id, data must not change afterwards
+func (t *transferState) add(id int, data []byte) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.chunks[id]; ok {
+		return
+	}
+	t.chunks[id] = data
+
+	if t.path == "" {
+		return
+	}
+	record := make([]byte, IDSIZE+4, IDSIZE+4+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(id))
+	binary.BigEndian.PutUint32(record[4:8], uint32(len(data)))
+
+	file, err := os.OpenFile(t.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err == nil {
+		_, err = file.Write(append(record, data...))
+		file.Close()
+	}
+	if err != nil {
+		fmt.Printf("[Client] Error writing resume state %v: %v\n", t.path, err)
+	}
+}
+
+// get returns chunk id, nil if it wasn't decoded
+func (t *transferState) get(id int) []byte {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.chunks[id]
+}
+
+// count is the number of chunks kept
+func (t *transferState) count() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return len(t.chunks)
+}
+
+// remove forgets the chunks and deletes their file once the request is
+// over or can't be resumed
+func (t *transferState) remove() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.path != "" {
+		os.Remove(t.path)
+		t.path = ""
+	}
+	t.chunks = make(map[int][]byte)
+}
@@ -0,0 +1,128 @@
+package xnc
+
+import (
+	"github.com/cloud9-tools/go-galoisfield"
+	"github.com/itzmeanjan/kodr"
+)
+
+// SeedVector expands seed into a coding vector of pieceCount coefficients.
+// The coefficients are the bytes of successive SplitMix64 outputs, least
+// significant byte first, starting from seed as the generator state. Both
+// sides of a seeded transfer must expand seeds the same way.
+func SeedVector(seed uint64, pieceCount int) kodr.CodingVector {
+	vector := make(kodr.CodingVector, pieceCount)
+
+	state := seed
+	for i := 0; i < pieceCount; i += 8 {
+		out := splitMix64(&state)
+		for j := i; j < i+8 && j < pieceCount; j++ {
+			vector[j] = byte(out)
+			out >>= 8
+		}
+	}
+
+	return vector
+}
+
+func splitMix64(state *uint64) uint64 {
+	*state += 0x9e3779b97f4a7c15
+	z := *state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// SeededEncoder codes the pieces of one chunk like full.FullRLNCEncoder,
+// or like systematic.SystematicRLNCEncoder if systematic is set, except
+// that the coefficients of every coded piece come from a seed so a frame
+// only has to carry the seed. Seeds are drawn from a SplitMix64 generator
+// started at RNGSEED mixed with the chunk id.
+type SeededEncoder struct {
+	field      *galoisfield.GF
+	pieces     []kodr.Piece
+	systematic bool
+	state      uint64
+	sent       int
+}
+
+func NewSeededEncoder(pieces []kodr.Piece, id int, systematic bool) *SeededEncoder {
+	state := uint64(RNGSEED) ^ uint64(id)<<32
+	splitMix64(&state)
+
+	return &SeededEncoder{
+		field:      galoisfield.DefaultGF256,
+		pieces:     pieces,
+		systematic: systematic,
+		state:      state,
+	}
+}
+
+// NewSeededEncoderWithPieceCount splits data into pieceCount pieces, the
+// last one zero padded
+func NewSeededEncoderWithPieceCount(data []byte, pieceCount uint, id int, systematic bool) (*SeededEncoder, error) {
+	pieces, _, err := kodr.OriginalPiecesFromDataAndPieceCount(data, pieceCount)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSeededEncoder(pieces, id, systematic), nil
+}
+
+// forSource moves the seeds of a chunk to the stream of the server with
+// index source in a multi-source transfer, source 0 keeps them as they are
+func (e *SeededEncoder) forSource(source int) {
+	if source == 0 {
+		return
+	}
+
+	e.state ^= uint64(source)
+	splitMix64(&e.state)
+}
+
+// SeededPiece returns the next piece along with the seed of its
+// coefficients. With systematic coding the first pieces are sent uncoded,
+// for those uncoded is set and seed is the index of the piece.
+func (e *SeededEncoder) SeededPiece() (seed uint64, piece kodr.Piece, uncoded bool) {
+	if e.systematic && e.sent < len(e.pieces) {
+		idx := e.sent
+		e.sent++
+
+		piece = make(kodr.Piece, len(e.pieces[idx]))
+		copy(piece, e.pieces[idx])
+		return uint64(idx), piece, true
+	}
+	e.sent++
+
+	seed = splitMix64(&e.state)
+	vector := SeedVector(seed, len(e.pieces))
+
+	piece = make(kodr.Piece, len(e.pieces[0]))
+	for i := range e.pieces {
+		piece.Multiply(e.pieces[i], vector[i], e.field)
+	}
+
+	return seed, piece, false
+}
+
+// skip passes over the next n pieces, so a chunk coded again carries on
+// with seeds the client hasn't seen yet
+func (e *SeededEncoder) skip(n int) {
+	for ; n > 0; n-- {
+		if !e.systematic || e.sent >= len(e.pieces) {
+			splitMix64(&e.state)
+		}
+		e.sent++
+	}
+}
+
+// CodedPiece is SeededPiece with the coefficients expanded
+func (e *SeededEncoder) CodedPiece() *kodr.CodedPiece {
+	seed, piece, uncoded := e.SeededPiece()
+	if uncoded {
+		vector := make(kodr.CodingVector, len(e.pieces))
+		vector[seed] = 1
+		return &kodr.CodedPiece{Vector: vector, Piece: piece}
+	}
+
+	return &kodr.CodedPiece{Vector: SeedVector(seed, len(e.pieces)), Piece: piece}
+}
@@ -2,54 +2,118 @@ package xnc
 
 import (
 	"context"
+	"crypto/sha512"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"math/rand"
-	"os"
+	"io/fs"
+	"math"
+	"net/http"
+	"path"
 	"path/filepath"
 	"strings"
-
 	"time"
 
-	"github.com/itzmeanjan/kodr"
-	"github.com/itzmeanjan/kodr/full"
 	"github.com/lucas-clemente/quic-go"
 )
 
-func Server(ctx context.Context, rootDir string) {
+// Server serves the files of its Config.Files, or the ones under its
+// Config.RootDir, each request is coded with the chunk size and piece
+// count its client proposed.
+type Server struct {
+	conf *Config
+	// split chunks shared by requests, nil if Config.CacheBytes is 0
+	cache   *chunkCache
+	metrics *serverMetrics
+}
 
-	quicConf := &quic.Config{}
-	tlsConf := GenerateTLSConfig()
-	if tlsConf == nil {
-		return
+// NewServer copies conf, unset fields are taken from DefaultConfig
+func NewServer(conf *Config) (*Server, error) {
+	conf = conf.withDefaults()
+
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	if conf.Files == nil {
+		conf.Files = DirSource(conf.RootDir)
+	}
+
+	if conf.TLSConfig == nil {
+		conf.TLSConfig = GenerateTLSConfig()
+		if conf.TLSConfig == nil {
+			return nil, fmt.Errorf("server has no TLS config\n")
+		}
 	}
 
-	listener, err := quic.ListenAddr(clientaddr, tlsConf, quicConf)
+	s := &Server{conf: conf, metrics: newServerMetrics()}
+	if conf.CacheBytes > 0 {
+		s.cache = newChunkCache(conf.CacheBytes, conf.CachePool)
+	}
+
+	return s, nil
+}
+
+// CacheStats reports how the server's chunk cache did, all zero without
+// a cache
+func (s *Server) CacheStats() CacheStats {
+	if s.cache == nil {
+		return CacheStats{}
+	}
+	return s.cache.Stats()
+}
+
+// Stats reports what the server sent since it started
+func (s *Server) Stats() ServerStats {
+	return s.metrics.Stats()
+}
+
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	quicConf := &quic.Config{}
+
+	listener, err := quic.ListenAddr(s.conf.Addr, s.conf.TLSConfig, quicConf)
 	if err != nil {
 		fmt.Println("[Server] Failed to start server:", err)
-		return
+		return err
 	}
 	defer listener.Close()
 
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves the sessions of listener until ctx is done or listener is
+// closed, the listener is left to the caller
+func (s *Server) Serve(ctx context.Context, listener quic.Listener) error {
+	if s.conf.MetricsAddr != "" {
+		metrics := &http.Server{Addr: s.conf.MetricsAddr, Handler: s.MetricsHandler()}
+		defer metrics.Close()
+
+		go func() {
+			fmt.Printf("[Server] Serving metrics on http://%v/metrics\n", s.conf.MetricsAddr)
+			if err := metrics.ListenAndServe(); err != http.ErrServerClosed {
+				fmt.Printf("[Server] Error serving metrics: %v\n", err)
+			}
+		}()
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			fmt.Println("[Server] Server shutting down")
-			return
+			return nil
 		default:
 			sess, err := listener.Accept()
 			if err != nil {
 				fmt.Println("[Server] Failed to accept session:", err)
-				return
+				return err
 			}
 
-			go handleSession(sess, rootDir)
+			go s.handleSession(sess)
 		}
 	}
 }
 
-func handleSession(sess quic.Session, rootDir string) {
+func (s *Server) handleSession(sess quic.Session) {
 	// After file is fully received
 	fmt.Println("[Server] Session started, waiting for file transfers...")
 
@@ -67,134 +131,855 @@ func handleSession(sess quic.Session, rootDir string) {
 		}
 
 		go func() {
-			fmt.Println("[Server] Stream accepted, waiting for init packet...")
-
-			accu_recv := 0
-			buffer := make([]byte, INITSIZE)
-			for accu_recv < INITSIZE {
-				n, err := stream.Read(buffer[accu_recv:])
-				if err != nil {
-					if err == io.EOF {
-						fmt.Printf("[Server] Stream closed by server")
-						break
-					}
-					fmt.Println("[Server] Error reading from stream:", err)
-					continue // or handle the error appropriately
-				} else {
-					accu_recv += n
-				}
-			}
-
-			init, err := DecodeInit(buffer)
-			if err != nil {
-				fmt.Printf("[Server] Error decoding init packet: %v", err)
+			init, conf, ok := readRequest(stream, s.conf)
+			if !ok {
+				stream.Close()
 				return
 			}
-			fmt.Printf("[Server] Client request file: %v\n", init.Filename)
-			filepath := filepath.Join(rootDir, init.Filename)
 
-			if init.Type == TYPE_INIT_ENC {
-				sendFile(stream, filepath, true)
-			} else {
-				sendFile(stream, filepath, false)
+			done := s.metrics.started()
+			defer done()
+
+			conn := newPktConn(sess, stream, conf.Datagram)
+			conn.metrics = s.metrics
+			if conf.Steer {
+				conn.steer = newPathSteer(sess)
 			}
+			sendFile(sess, stream, conn, conf, init, s.cache)
+
+			// lets the client's Reader see the end of a request which failed
+			stream.Close()
 		}()
 	}
 }
 
-func sendFile(stream quic.Stream, filename string, encode bool) {
-	rand.Seed(42)
+// readRequest reads the init packet of a request and the Config it is
+// coded with, starting from the settings in base. A request which can't be
+// served is answered with STATUS_BAD_REQUEST.
+func readRequest(stream quic.Stream, base *Config) (XNC_INIT, *Config, bool) {
+	fmt.Println("[Server] Stream accepted, waiting for init packet...")
+
+	buffer := make([]byte, INITSIZE)
+	if _, err := io.ReadFull(stream, buffer); err != nil {
+		// the client is gone, there is nobody to answer
+		fmt.Println("[Server] Error reading init packet:", err)
+		return XNC_INIT{}, nil, false
+	}
+
+	init, err := DecodeInit(buffer)
+	if err != nil {
+		fmt.Printf("[Server] Error decoding init packet: %v", err)
+		sendStatus(stream, STATUS_BAD_REQUEST)
+		return XNC_INIT{}, nil, false
+	}
+	fmt.Printf("[Server] Client request file: %v, chunk size %v, %v pieces\n", init.Filename, init.ChunkSize, init.PieceCount)
+
+	// the request is coded with the parameters the client asked for
+	conf := *base
+	conf.ChunkSize = init.ChunkSize
+	conf.PieceCount = init.PieceCount
+	conf.Datagram = init.Flags&INITFLAG_DATAGRAM != 0
+	conf.Digest = init.Flags&INITFLAG_DIGEST != 0
+	conf.Seed = init.Flags&INITFLAG_SEED != 0
+	if init.Window > 0 {
+		conf.Generations = init.Window
+	}
+
+	if conf.Datagram && init.Type == TYPE_INIT {
+		fmt.Printf("[Server] Uncoded transfer can't run over datagrams\n")
+		sendStatus(stream, STATUS_BAD_REQUEST)
+		return XNC_INIT{}, nil, false
+	}
+	if conf.Seed && init.Type != TYPE_INIT_ENC && init.Type != TYPE_INIT_SYS {
+		fmt.Printf("[Server] Only chunk coding can send seeded coefficients\n")
+		sendStatus(stream, STATUS_BAD_REQUEST)
+		return XNC_INIT{}, nil, false
+	}
+	if init.Flags&INITFLAG_RESUME != 0 && init.Type != TYPE_INIT_ENC && init.Type != TYPE_INIT_SYS {
+		fmt.Printf("[Server] Only chunk coding can resume a transfer\n")
+		sendStatus(stream, STATUS_BAD_REQUEST)
+		return XNC_INIT{}, nil, false
+	}
+	if err := conf.Validate(); err != nil {
+		fmt.Printf("[Server] Rejecting request: %v", err)
+		sendStatus(stream, STATUS_BAD_REQUEST)
+		return XNC_INIT{}, nil, false
+	}
+
+	return init, &conf, true
+}
+
+func sendFile(sess quic.Session, stream quic.Stream, conn *pktConn, conf *Config, init XNC_INIT, cache *chunkCache) {
+	filename := init.Filename
 
-	file, err := os.Open(filename)
+	file, err := conf.Files.Open(filename)
 	if err != nil {
-		fmt.Errorf("[Server] Error opening file: %v", err)
+		fmt.Printf("[Server] Error opening file: %v\n", err)
+		sendStatus(stream, openStatus(err))
 		return
 	}
 
 	defer file.Close() // Ensure the file is closed after reading
 
-	filebytes, err := io.ReadAll(file)
+	info, err := file.Stat()
 	if err != nil {
-		fmt.Errorf("[Server] Error reading file: %v", err)
+		fmt.Printf("[Server] Error reading file: %v\n", err)
+		sendStatus(stream, STATUS_INTERNAL)
 		return
 	}
 
-	fmt.Printf("[Server] Read %d bytes from %v\n", len(filebytes), filename)
-	chunks := SpiltFile(filebytes, CHUNKSIZE)
-	fmt.Printf("[Server] Split file into %v chunks\n", len(chunks))
+	// only the requested range is coded, a range running past the end of
+	// the file is cut short like an HTTP range
+	if init.Offset > info.Size() {
+		fmt.Printf("[Server] Range starting at %v is outside of %v\n", init.Offset, filename)
+		sendStatus(stream, STATUS_BAD_REQUEST)
+		return
+	}
+	size := info.Size() - init.Offset
+	if init.Length > 0 && init.Length < size {
+		size = init.Length
+	}
+	chunkSize := int64(conf.ChunkSize)
+	if (size+chunkSize-1)/chunkSize > math.MaxUint32 {
+		fmt.Printf("[Server] Error reading file: %v is too large\n", filename)
+		sendStatus(stream, STATUS_INTERNAL)
+		return
+	}
+	section := io.NewSectionReader(file, init.Offset, size)
 
-	var size int
-	var pieces uint
-	if encode {
-		pieces = CODEDPIECECNT
-	} else {
-		pieces = PIECECNT
+	reply := XNC_INFO{
+		Type:     TYPE_INFO,
+		Status:   STATUS_OK,
+		ChunkNum: int((size + chunkSize - 1) / chunkSize),
+		FileSize: int(size),
+		Version:  info.ModTime().UnixNano(),
 	}
 
-	for i := 0; i < len(chunks); i++ {
-		if i == len(chunks)-1 {
-			size = len(filebytes) - CHUNKSIZE*(len(chunks)-1)
-		} else {
-			size = CHUNKSIZE
+	var resumed []bool
+	if init.Flags&INITFLAG_RESUME != 0 {
+		resumed, err = readResume(stream, reply)
+		if err != nil {
+			fmt.Printf("[Server] Error reading resume request: %v\n", err)
+			sendStatus(stream, STATUS_BAD_REQUEST)
+			return
 		}
+	}
 
-		fmt.Printf("[Server] Sending chunk %v, %v pieces\n", i, pieces)
-		// hasher := sha512.New512_224()
-		// hasher.Write(chunks[i])
+	if err := sendInfo(stream, reply); err != nil {
+		fmt.Printf("[Server] Error sending file info: %v\n", err)
+		return
+	}
 
-		codedPieces := make([]*kodr.CodedPiece, 0, CODEDPIECECNT)
+	fmt.Printf("[Server] Sending %d bytes from %v at offset %d\n", size, filename, init.Offset)
 
-		if encode {
-			enc, err := full.NewFullRLNCEncoderWithPieceCount(chunks[i], PIECECNT)
-			if err != nil {
-				log.Printf("Error: %s\n", err.Error())
+	if init.Type == TYPE_INIT_SW {
+		// window coding has no chunks, only the whole file is digested
+		if conf.Digest {
+			digest := sha512.New512_224()
+			if _, err := io.Copy(digest, io.NewSectionReader(section, 0, size)); err != nil {
+				fmt.Printf("[Server] Error reading file: %v\n", err)
 				return
 			}
-
-			for j := 0; j < int(CODEDPIECECNT); j++ {
-				codedPieces = append(codedPieces, enc.CodedPiece())
+			if err := sendDigest(conn, int((size+chunkSize-1)/chunkSize), digest.Sum(nil)); err != nil {
+				fmt.Printf("[Server] Error sending digest: %v\n", err)
+				return
 			}
 		}
 
-		for s := 0; s < int(pieces); s++ {
-			var pktE []byte
+		if err := sendWindow(sess, stream, conn, conf, section, size); err != nil {
+			fmt.Printf("[Server] Error sending window coded file: %v\n", err)
+			return
+		}
+
+		sendEnd(conn, 0)
+		fmt.Printf("[Server] Finished sending file\n")
+		return
+	}
+
+	key := fileKey{
+		name:    cleanName(filename),
+		size:    info.Size(),
+		modTime: info.ModTime().UnixNano(),
+		offset:  init.Offset,
+		length:  size,
+	}
+	gens := newGenerationReader(section, size, conf, init.Type, init.Source, cache, key, resumed)
+	defer gens.Close()
+	fmt.Printf("[Server] Split file into %v chunks\n", gens.Count())
+
+	if conf.Digest && gens.Count() == 0 {
+		if err := sendDigest(conn, 0, chunkDigest(nil)); err != nil {
+			fmt.Printf("[Server] Error sending digest: %v\n", err)
+			return
+		}
+	}
 
-			if encode {
-				pktE, err = GetXNCEncPkt(size, i, len(chunks), codedPieces[s])
-			} else {
-				pktE, err = GetXNCPkt(size, i, len(chunks), chunks[i][s*PIECESIZE:(s+1)*PIECESIZE])
+	if resumed != nil {
+		have := 0
+		for _, done := range resumed {
+			if done {
+				have++
 			}
+		}
+		fmt.Printf("[Server] Resuming, client has %v of %v chunks\n", have, gens.Count())
+	}
+
+	if init.Type != TYPE_INIT {
+		// sendCoded sends END itself, a client may still be missing
+		// chunks after it
+		if err := sendCoded(sess, stream, conn, conf, gens); err != nil {
+			fmt.Printf("[Server] Error sending coded file: %v\n", err)
+			return
+		}
+	} else {
+		pieceSize := conf.PieceSize()
 
+		for i := 0; i < gens.Count(); i++ {
+			gen, err := gens.Next()
 			if err != nil {
-				fmt.Printf("Error encoding packet data: %v", err)
+				fmt.Printf("[Server] Error reading file: %v\n", err)
+				return
+			}
+			if err := sendDigests(conn, gen, gens.Count()); err != nil {
+				fmt.Printf("[Server] Error sending digest: %v\n", err)
 				return
 			}
 
-			_, err = stream.Write(pktE)
-			if err != nil {
-				if err == io.EOF {
-					// The stream has been closed by the server, gracefully exit the loop.
+			fmt.Printf("[Server] Sending chunk %v, %v pieces\n", i, conf.PieceCount)
+
+			for s := 0; s < conf.PieceCount; s++ {
+				pktE, err := GetXNCPkt(gen.size, i, gens.Count(), s, conf.PieceCount, gen.data[s*pieceSize:(s+1)*pieceSize])
+				if err != nil {
+					fmt.Printf("Error encoding packet data: %v", err)
+					return
+				}
+
+				if err := conn.WritePkt(pktE); err != nil {
 					fmt.Printf("Stream closed by the client, stopping write operations.\n")
-					break
-				} else if strings.Contains(err.Error(), "closed stream") {
-					fmt.Printf("Stream closed by the server, stopping write operations.\n")
-					continue
-				} else {
-					// Handle other errors that might not necessitate stopping.
-					fmt.Printf("Error writing to stream: %v\n", err)
-					continue
+					return
 				}
 			}
-			// loss debug
-			// fmt.Printf("[Server] Chunk %d, sent %d\n", i, s)
 		}
+
+		sendEnd(conn, gens.Count()-1)
+	}
+
+	fmt.Printf("[Server] Finished sending file\n")
+}
+
+// resolvePath maps a requested file name into rootDir. Names are relative
+// to rootDir even with a leading slash, and any ".." element is refused
+// so a request can never escape it.
+func resolvePath(rootDir string, name string) (string, bool) {
+	for _, elem := range strings.Split(filepath.ToSlash(name), "/") {
+		if elem == ".." {
+			return "", false
+		}
+	}
+
+	return filepath.Join(rootDir, filepath.FromSlash(path.Clean("/"+name))), true
+}
+
+// openStatus is the status reported to the client for a FileSource.Open
+// error
+func openStatus(err error) byte {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return STATUS_NOT_FOUND
+	case errors.Is(err, fs.ErrPermission):
+		return STATUS_FORBIDDEN
+	default:
+		return STATUS_INTERNAL
+	}
+}
+
+// sendInfo writes the answer to a request on its stream, the data frames
+// only follow a STATUS_OK
+func sendInfo(stream quic.Stream, info XNC_INFO) error {
+	pkt, err := EncodeInfo(info)
+	if err != nil {
+		return err
+	}
+
+	_, err = stream.Write(pkt)
+	return err
+}
+
+func sendStatus(stream quic.Stream, status byte) {
+	if err := sendInfo(stream, XNC_INFO{Type: TYPE_INFO, Status: status}); err != nil {
+		fmt.Printf("[Server] Error sending status: %v\n", err)
+	}
+}
+
+// readResume reads the XNC_RESUME following a request and returns the
+// chunks the client already has, or nil if they were decoded from another
+// version of the file than the one the request is answered with
+func readResume(stream quic.Stream, info XNC_INFO) ([]bool, error) {
+	header := make([]byte, RESUMEHEADERSIZE)
+	if _, err := io.ReadFull(stream, header); err != nil {
+		return nil, err
+	}
+
+	size, err := ResumeSize(header)
+	if err != nil {
+		return nil, err
 	}
 
+	// a bitmap of another length is of another file, it is skipped
+	// without holding it in memory
+	if size != RESUMEHEADERSIZE+(info.ChunkNum+7)/8 {
+		fmt.Printf("[Server] Resume request is for another version of the file, sending all of it\n")
+		_, err := io.CopyN(io.Discard, stream, int64(size-RESUMEHEADERSIZE))
+		return nil, err
+	}
+
+	pkt := make([]byte, size)
+	copy(pkt, header)
+	if _, err := io.ReadFull(stream, pkt[RESUMEHEADERSIZE:]); err != nil {
+		return nil, err
+	}
+
+	resume, err := DecodeResume(pkt)
+	if err != nil {
+		return nil, err
+	}
+	if resume.FileSize != info.FileSize || resume.ChunkNum != info.ChunkNum || resume.Version != info.Version {
+		fmt.Printf("[Server] Resume request is for another version of the file, sending all of it\n")
+		return nil, nil
+	}
+
+	return resume.Done, nil
+}
+
+// sendDigests sends the digest of gen ahead of its pieces, and the digest
+// of the whole file along with the last generation
+func sendDigests(conn *pktConn, gen generation, count int) error {
+	if gen.digest == nil {
+		return nil
+	}
+
+	if err := sendDigest(conn, gen.id, gen.digest); err != nil {
+		return err
+	}
+	if gen.fileDigest != nil {
+		return sendDigest(conn, count, gen.fileDigest)
+	}
+
+	return nil
+}
+
+func sendDigest(conn *pktConn, id int, digest []byte) error {
+	pkt, err := EncodeDigest(id, digest)
+	if err != nil {
+		return err
+	}
+
+	return conn.WriteStreamPkt(pkt)
+}
+
+func sendEnd(conn *pktConn, id int) {
 	for i := 0; i < 5; i++ {
-		endpkt := EncodeEND(len(chunks)-1, encode)
-		stream.Write(endpkt)
+		endpkt := EncodeEND(id)
+		conn.WritePkt(endpkt)
 		time.Sleep(5 * time.Millisecond)
 	}
+}
 
-	fmt.Printf("[Server] Finished sending file\n")
+// chunkState is the server's view of one chunk while it is being coded
+type chunkState struct {
+	enc      pieceEncoder
+	size     int
+	sent     int
+	acked    bool
+	required int
+	received int
+	seq      int
+	decoded  bool
+	// pieces of the current burst the client still needs, the rest of
+	// the burst is redundancy
+	needed int
+	// when the first piece was sent
+	started time.Time
+	repairTrack
+}
+
+// sendCoded streams coded pieces of every chunk until the client reports
+// each of them decoded. A burst of PieceCount pieces plus the estimator's
+// redundancy is sent up front, and chunks that the client still can't
+// decode are topped up from its feedback. With systematic coding the burst
+// starts with the chunk's uncoded pieces and everything after is coded.
+// Once every chunk is decoded END is sent, and chunks the client still
+// asks for after it are coded again.
+func sendCoded(sess quic.Session, stream quic.Stream, conn *pktConn, conf *Config, gens *generationReader) error {
+	pieceCount := uint(conf.PieceCount)
+
+	feedback := make(chan XNC_ACK, maxInFlight*conf.PieceCount)
+	done := make(chan struct{})
+	defer close(done)
+
+	go readACKs(stream, feedback, done)
+
+	est := newLossEstimator()
+	chunkNum := gens.Count()
+	states := make([]*chunkState, chunkNum)
+	next, inflight, decoded := 0, 0, 0
+	// first chunk the client hasn't decoded, it keeps conf.Generations
+	// chunks open from there
+	first := 0
+	// pieces sent so far, the place of the last piece the client reported
+	// and when it last gave feedback
+	order, heard := 0, -1
+	lastHeard := time.Now()
+
+	getPkt := GetXNCEncPkt
+	if gens.initType == TYPE_INIT_SYS {
+		getPkt = GetXNCSysPkt
+	}
+
+	// seeded frames carry the seed of their coefficients, and uncoded
+	// pieces go out as plain frames with their index as Seq
+	frame := func(st *chunkState, i int) ([]byte, error) {
+		enc, ok := st.enc.(*SeededEncoder)
+		if !ok {
+			return getPkt(st.size, i, chunkNum, st.sent, st.enc.CodedPiece())
+		}
+
+		seed, piece, uncoded := enc.SeededPiece()
+		if uncoded {
+			return GetXNCPkt(st.size, i, chunkNum, int(seed), conf.PieceCount, piece)
+		}
+		return GetXNCSeedPkt(st.size, i, chunkNum, st.sent, conf.PieceCount, seed, piece)
+	}
+
+	sendOne := func(i int) error {
+		st := states[i]
+
+		pktE, err := frame(st, i)
+		if err != nil {
+			return err
+		}
+
+		if err := conn.WritePiece(pktE, st.needed <= 0); err != nil {
+			return err
+		}
+		if st.started.IsZero() {
+			st.started = time.Now()
+		}
+		st.needed--
+		st.sent++
+		st.sentAt(order)
+		order++
+
+		return nil
+	}
+
+	// a burst covers the required pieces plus the estimator's redundancy
+	burstOf := func(i int, required int) burst {
+		states[i].needed = required
+		return burst{chunk: i, pieces: required + int(est.Redundancy(uint(required)))}
+	}
+
+	send := func(i int, required int) error {
+		return interleave([]burst{burstOf(i, required)}, sendOne)
+	}
+
+	// reopen codes chunk i again after the client reported it decoded but
+	// asked for more of it, the sequence numbers and seeds carry on
+	reopen := func(i int) error {
+		gen, err := gens.Reopen(i)
+		if err != nil {
+			return err
+		}
+
+		old := states[i]
+		switch enc := gen.enc.(type) {
+		case *SeededEncoder:
+			enc.skip(old.sent)
+		case *pooledEncoder:
+			enc.skip(old.sent)
+		case *randEncoder:
+			enc.skip(old.sent)
+		}
+		states[i] = &chunkState{enc: gen.enc, size: gen.size, sent: old.sent, seq: old.seq, repairTrack: repairTrack{orders: old.orders}}
+
+		decoded--
+		inflight++
+		if i < first {
+			first = i
+		}
+
+		return nil
+	}
+
+	apply := func(ack XNC_ACK) error {
+		if ack.Type == TYPE_ACK_ABORT {
+			return fmt.Errorf("transfer aborted by client at chunk %v", ack.ChunkId)
+		}
+
+		if ack.ChunkId < 0 || ack.ChunkId >= next {
+			return nil
+		}
+
+		st := states[ack.ChunkId]
+		lastHeard = time.Now()
+		heard = st.report(ack.Seq, heard)
+		if st.decoded {
+			if ack.Type != TYPE_ACK_MORE {
+				return nil
+			}
+			if err := reopen(ack.ChunkId); err != nil {
+				return err
+			}
+			st = states[ack.ChunkId]
+		}
+
+		if ack.Seq > st.seq {
+			st.seq = ack.Seq
+		}
+
+		switch ack.Type {
+		case TYPE_ACK_RANK:
+			st.acked = true
+			st.required = ack.Required
+			st.received = ack.Received
+
+		case TYPE_ACK_DECODED:
+			st.decoded = true
+			st.received = ack.Received
+			st.enc = nil
+			inflight--
+			decoded++
+			est.Update(st.seq+1, st.received)
+			if conn.metrics != nil {
+				conn.metrics.decoded(time.Since(st.started))
+			}
+			for first < next && states[first].decoded {
+				first++
+			}
+
+		case TYPE_ACK_MORE:
+			st.acked = true
+			st.required = ack.Required
+			if conn.metrics != nil {
+				conn.metrics.repaired()
+			}
+			return send(ack.ChunkId, ack.Required)
+		}
+
+		return nil
+	}
+
+	for {
+	DRAIN:
+		for decoded < chunkNum {
+			select {
+			case ack, ok := <-feedback:
+				if !ok {
+					return fmt.Errorf("client stopped sending feedback")
+				}
+				if err := apply(ack); err != nil {
+					return err
+				}
+			default:
+				break DRAIN
+			}
+		}
+
+		if decoded == chunkNum {
+			sendEnd(conn, chunkNum-1)
+
+			// the client closes its side of the stream once it has every
+			// chunk, or asks again for the ones it is missing
+			linger := time.After(endLinger)
+			for decoded == chunkNum {
+				select {
+				case ack, ok := <-feedback:
+					if !ok {
+						return nil
+					}
+					if err := apply(ack); err != nil {
+						return err
+					}
+				case <-linger:
+					return nil
+				}
+			}
+			continue
+		}
+
+		timeout := repairTimeout(sess.GetRtt())
+		var repairs []burst
+		for i := 0; i < next; i++ {
+			st := states[i]
+			if st.decoded {
+				continue
+			}
+
+			caughtUp := st.acked && st.seq+1 >= st.sent
+			repair, probe := st.due(caughtUp, i == first, heard, lastHeard, timeout)
+			if !repair {
+				continue
+			}
+
+			required := int(pieceCount)
+			if st.acked {
+				required = st.required
+			}
+			if probe {
+				required = 1
+			}
+
+			if conn.metrics != nil {
+				conn.metrics.repaired()
+			}
+			repairs = append(repairs, burstOf(i, required))
+		}
+		if err := interleave(repairs, sendOne); err != nil {
+			return err
+		}
+
+		// up to Interleave new chunks are opened together and their pieces
+		// sent in turn, as long as the client has room for them
+		var bursts []burst
+		for next < chunkNum && inflight < maxInFlight && next < first+conf.Generations && len(bursts) < conf.Interleave {
+			gen, err := gens.Next()
+			if err != nil {
+				return err
+			}
+			if err := sendDigests(conn, gen, chunkNum); err != nil {
+				return err
+			}
+			// the client decoded the chunk before it resumed, only its
+			// digests are sent
+			if gen.resumed {
+				states[next] = &chunkState{size: gen.size, decoded: true}
+				next++
+				decoded++
+				for first < next && states[first].decoded {
+					first++
+				}
+				continue
+			}
+			states[next] = &chunkState{enc: gen.enc, size: gen.size}
+
+			bursts = append(bursts, burstOf(next, int(pieceCount)))
+			next++
+			inflight++
+		}
+		if len(bursts) > 0 {
+			if err := interleave(bursts, sendOne); err != nil {
+				return err
+			}
+			continue
+		}
+		// the last chunks were all resumed
+		if decoded == chunkNum {
+			continue
+		}
+
+		select {
+		case ack, ok := <-feedback:
+			if !ok {
+				return fmt.Errorf("client stopped sending feedback")
+			}
+			if err := apply(ack); err != nil {
+				return err
+			}
+		case <-time.After(timeout):
+		}
+	}
+}
+
+// burst is a number of pieces to send for one chunk
+type burst struct {
+	chunk  int
+	pieces int
+}
+
+// interleave sends the pieces of every burst with send, one piece of each
+// chunk in turn, so that a run of lost packets is spread over the chunks
+// instead of wiping out one of them
+func interleave(bursts []burst, send func(chunk int) error) error {
+	for left := len(bursts) > 0; left; {
+		left = false
+		for k := range bursts {
+			if bursts[k].pieces == 0 {
+				continue
+			}
+
+			if err := send(bursts[k].chunk); err != nil {
+				return err
+			}
+			bursts[k].pieces--
+			left = left || bursts[k].pieces > 0
+		}
+	}
+
+	return nil
+}
+
+// sendWindow streams the file with sliding window coding. Every new source
+// piece entering the window is sent in one coded piece, followed by the
+// estimator's redundancy once per window, and the window slides forward
+// as the client acknowledges pieces it delivered in order.
+func sendWindow(sess quic.Session, stream quic.Stream, conn *pktConn, conf *Config, file io.ReaderAt, size int64) error {
+	window := uint(conf.PieceCount)
+
+	feedback := make(chan XNC_ACK, maxInFlight*conf.PieceCount)
+	done := make(chan struct{})
+	defer close(done)
+
+	go readACKs(stream, feedback, done)
+
+	enc := NewSlidingWindowEncoderFromReader(file, size, conf.PieceSize(), window)
+	if enc.PieceCount() == 0 {
+		return nil
+	}
+	fmt.Printf("[Server] Sending %v pieces, window %v\n", enc.PieceCount(), window)
+
+	est := newLossEstimator()
+	sent, pushed := 0, 0
+	var lastSent time.Time
+	var acked, finished bool
+	seq, required := 0, 0
+	sampleSeq, sampleRecv := 0, 0
+
+	send := func(pieces int) error {
+		for s := 0; s < pieces; s++ {
+			start, end := enc.Window()
+			_, piece := enc.CodedPiece()
+
+			pktE, err := GetXNCSWPkt(int(size), start, end-start, sent, piece)
+			if err != nil {
+				return err
+			}
+
+			if err := conn.WritePkt(pktE); err != nil {
+				return err
+			}
+			sent++
+		}
+		lastSent = time.Now()
+
+		return nil
+	}
+
+	apply := func(ack XNC_ACK) error {
+		if ack.Seq > seq {
+			seq = ack.Seq
+		}
+
+		switch ack.Type {
+		case TYPE_ACK_ABORT:
+			return fmt.Errorf("transfer aborted by client at piece %v", ack.ChunkId)
+
+		case TYPE_ACK_DECODED:
+			finished = true
+
+		case TYPE_ACK_RANK:
+			acked = true
+			required = ack.Required
+			enc.Advance(uint(ack.ChunkId))
+
+			if seq+1-sampleSeq >= int(window) {
+				est.Update(seq+1-sampleSeq, ack.Received-sampleRecv)
+				sampleSeq, sampleRecv = seq+1, ack.Received
+			}
+
+		case TYPE_ACK_MORE:
+			return send(ack.Required)
+		}
+
+		return nil
+	}
+
+	for !finished {
+	DRAIN:
+		for !finished {
+			select {
+			case ack, ok := <-feedback:
+				if !ok {
+					return fmt.Errorf("client stopped sending feedback")
+				}
+				if err := apply(ack); err != nil {
+					return err
+				}
+			default:
+				break DRAIN
+			}
+		}
+
+		if finished {
+			break
+		}
+
+		if enc.Push() {
+			if err := send(1); err != nil {
+				return err
+			}
+
+			pushed++
+			if pushed%int(window) == 0 {
+				if err := send(int(est.Redundancy(window))); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := enc.Err(); err != nil {
+			return err
+		}
+
+		timeout := repairTimeout(sess.GetRtt())
+		caughtUp := acked && seq+1 >= sent
+		if (required > 0 && caughtUp) || time.Since(lastSent) >= timeout {
+			missing := required
+			if missing == 0 {
+				missing = 1
+			}
+
+			if err := send(missing + int(est.Redundancy(uint(missing)))); err != nil {
+				return err
+			}
+			continue
+		}
+
+		select {
+		case ack, ok := <-feedback:
+			if !ok {
+				return fmt.Errorf("client stopped sending feedback")
+			}
+			if err := apply(ack); err != nil {
+				return err
+			}
+		case <-time.After(timeout - time.Since(lastSent)):
+		}
+	}
+
+	return nil
+}
+
+// readACKs forwards the client's feedback to the sender until the stream
+// is closed or the sender is done.
+func readACKs(stream quic.Stream, feedback chan<- XNC_ACK, done <-chan struct{}) {
+	defer close(feedback)
+
+	for {
+		buf := make([]byte, ACKSIZE)
+		if _, err := io.ReadFull(stream, buf); err != nil {
+			return
+		}
+
+		ack, err := DecodeACK(buf)
+		if err != nil {
+			fmt.Printf("[Server] Error decoding ack: %v\n", err)
+			return
+		}
+
+		select {
+		case feedback <- ack:
+		case <-done:
+			return
+		}
+	}
 }
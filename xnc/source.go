@@ -0,0 +1,128 @@
+package xnc
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// SourceStats is what one server contributed to a transfer
+type SourceStats struct {
+	Addr string
+	// data frames received from the server
+	Pieces int
+	// pieces which raised the rank of their chunk
+	Innovative int
+	// bytes received on the wire
+	Bytes int
+}
+
+// source is one server a Reader receives the pieces of a file from, the
+// server the file was opened on comes first
+type source struct {
+	addr   string
+	stream quic.Stream
+	conn   *pktConn
+	// guarded by Reader.statsMutex
+	stats SourceStats
+
+	// feedback to the server, only used while chunks are decoded
+	acks     chan XNC_ACK
+	acksDone chan struct{}
+	// highest sequence number and pieces taken from the server, by chunk
+	seq      []int
+	received []int
+}
+
+// sourceFrame is a frame read from source src, or why it stopped
+type sourceFrame struct {
+	src int
+	pkt []byte
+	err error
+}
+
+func newSource(addr string, stream quic.Stream, conn *pktConn) *source {
+	return &source{
+		addr:   addr,
+		stream: stream,
+		conn:   conn,
+		stats:  SourceStats{Addr: addr},
+	}
+}
+
+// startFeedback starts writing feedback on chunks chunks to the server
+func (s *source) startFeedback(chunks int, pieceCount int) {
+	s.acks = make(chan XNC_ACK, maxInFlight*(pieceCount+1))
+	s.acksDone = make(chan struct{})
+	s.seq = make([]int, chunks)
+	s.received = make([]int, chunks)
+
+	go writeACKs(s.stream, s.acks, s.acksDone)
+}
+
+// stopFeedback returns once all feedback is written
+func (s *source) stopFeedback() {
+	close(s.acks)
+	<-s.acksDone
+}
+
+// add counts piece seq of chunk id, taken by the decoder
+func (s *source) add(id int, seq int) {
+	if seq > s.seq[id] {
+		s.seq[id] = seq
+	}
+	s.received[id]++
+}
+
+// ack reports on chunk id with the sequence numbers and piece counts of
+// this server, so its loss estimate only covers its own pieces
+func (s *source) ack(ackType byte, id int, required int) {
+	s.acks <- XNC_ACK{
+		Type:     ackType,
+		ChunkId:  id,
+		Required: required,
+		Received: s.received[id],
+		Seq:      s.seq[id],
+	}
+}
+
+// readSource merges the frames of source k into r.frames until quit is
+// closed. The first source ends the transfer when it fails, a mirror is
+// only left out, as is a mirror which doesn't serve the same file.
+func (r *Reader) readSource(k int, quit <-chan struct{}) {
+	src := r.srcs[k]
+
+	if k > 0 {
+		info, err := readInfoFrom(src.stream)
+		if err == nil && (info.Status != STATUS_OK || info.ChunkNum != r.chunks || info.FileSize != r.size) {
+			err = fmt.Errorf("status %d, %v bytes in %v chunks", info.Status, info.FileSize, info.ChunkNum)
+		}
+		if err != nil {
+			fmt.Printf("[Client] Leaving out mirror %v for %v: %v\n", src.addr, r.filename, err)
+			return
+		}
+		atomic.AddInt64(&r.received, int64(INFOSIZE))
+	}
+
+	for {
+		pkt, err := src.conn.ReadPkt()
+		if err != nil && k > 0 {
+			select {
+			case <-quit:
+			default:
+				fmt.Printf("[Client] Mirror %v stopped sending %v: %v\n", src.addr, r.filename, err)
+			}
+			return
+		}
+
+		select {
+		case r.frames <- sourceFrame{src: k, pkt: pkt, err: err}:
+		case <-quit:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
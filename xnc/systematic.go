@@ -0,0 +1,132 @@
+package xnc
+
+import (
+	"fmt"
+
+	"github.com/itzmeanjan/kodr"
+	"github.com/itzmeanjan/kodr/full"
+)
+
+// SystematicDecoder decodes one chunk sent with systematic coding, where
+// the first PieceCount pieces go out uncoded and only repair pieces are
+// coded. Uncoded pieces are stored as they are, Gaussian elimination only
+// starts once a coded piece is needed to fill a gap, so a chunk received
+// without loss costs no decoding at all.
+type SystematicDecoder struct {
+	expected uint
+	received uint
+	// uncoded pieces by index, and how many of them arrived
+	pieces  []kodr.Piece
+	uncoded uint
+	// next uncoded piece handed out by Ready
+	ready uint
+	// only set once a coded piece arrived, holds every piece since
+	decoder *full.FullRLNCDecoder
+}
+
+func NewSystematicDecoder(pieceCount uint) *SystematicDecoder {
+	return &SystematicDecoder{
+		expected: pieceCount,
+		pieces:   make([]kodr.Piece, pieceCount),
+	}
+}
+
+func (s *SystematicDecoder) GetRecv() uint {
+	return s.received
+}
+
+func (s *SystematicDecoder) IsDecoded() bool {
+	return s.Required() == 0
+}
+
+// Required is the number of innovative pieces still missing
+func (s *SystematicDecoder) Required() uint {
+	if s.decoder != nil {
+		return s.decoder.Required()
+	}
+	return s.expected - s.uncoded
+}
+
+func (s *SystematicDecoder) AddPiece(piece *kodr.CodedPiece) error {
+	if s.IsDecoded() {
+		return kodr.ErrAllUsefulPiecesReceived
+	}
+	if uint(len(piece.Vector)) != s.expected {
+		return fmt.Errorf("coding vector has %d elements, expected %d\n", len(piece.Vector), s.expected)
+	}
+	s.received++
+
+	if piece.IsSystematic() {
+		idx := systematicIndex(piece.Vector)
+		if s.pieces[idx] != nil {
+			return nil
+		}
+		s.pieces[idx] = piece.Piece
+		s.uncoded++
+
+		if s.decoder == nil {
+			return nil
+		}
+		return s.decoder.AddPiece(uncodedPiece(idx, piece.Piece, s.expected))
+	}
+
+	if s.decoder == nil {
+		s.decoder = full.NewFullRLNCDecoder(s.expected)
+		for i, p := range s.pieces {
+			if p == nil {
+				continue
+			}
+			if err := s.decoder.AddPiece(uncodedPiece(i, p, s.expected)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.decoder.AddPiece(piece)
+}
+
+// Ready returns the uncoded pieces following the ones it returned before,
+// up to the first one still missing
+func (s *SystematicDecoder) Ready() []kodr.Piece {
+	start := s.ready
+	for s.ready < s.expected && s.pieces[s.ready] != nil {
+		s.ready++
+	}
+
+	return s.pieces[start:s.ready]
+}
+
+// GetPieces returns every piece of the chunk once it is decoded
+func (s *SystematicDecoder) GetPieces() ([]kodr.Piece, error) {
+	if !s.IsDecoded() {
+		return nil, kodr.ErrMoreUsefulPiecesRequired
+	}
+	if s.decoder == nil {
+		return s.pieces, nil
+	}
+
+	return s.decoder.GetPieces()
+}
+
+// uncodedPiece copies piece i for the decoder, which reduces its pieces
+// in place while uncoded ones may already have been handed out
+func uncodedPiece(i int, piece kodr.Piece, pieceCount uint) *kodr.CodedPiece {
+	vector := make(kodr.CodingVector, pieceCount)
+	vector[i] = 1
+
+	copied := make(kodr.Piece, len(piece))
+	copy(copied, piece)
+
+	return &kodr.CodedPiece{Vector: vector, Piece: copied}
+}
+
+// systematicIndex is the position of the single 1 in an uncoded piece's
+// coding vector
+func systematicIndex(vector kodr.CodingVector) int {
+	for i, v := range vector {
+		if v != 0 {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,290 @@
+package xnc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// pktConn carries xnc data frames of one request, either on the request
+// stream itself or, in datagram mode, as unreliable QUIC datagrams tagged
+// with the stream id so that mp-quic never retransmits them and RLNC is
+// the only loss recovery. Frames which have to arrive, like digests, are
+// always sent on the stream.
+type pktConn struct {
+	sess     quic.Session
+	stream   quic.Stream
+	id       uint32
+	datagram bool
+	// picks the path of every coded piece if set, datagram mode only
+	steer *pathSteer
+	// counts the frames written if set, only on the server
+	metrics *serverMetrics
+	// datagrams of this request, only set on the receiving side
+	mux    *datagramMux
+	dgrams <-chan []byte
+
+	// frames read from the stream in datagram mode, started by the first
+	// ReadPkt so the stream is left alone until then
+	streamOnce sync.Once
+	streamPkts chan []byte
+	// why the stream ended, only read after streamPkts is closed
+	streamErr error
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newPktConn(sess quic.Session, stream quic.Stream, datagram bool) *pktConn {
+	return &pktConn{
+		sess:     sess,
+		stream:   stream,
+		id:       uint32(stream.StreamID()),
+		datagram: datagram,
+		closed:   make(chan struct{}),
+	}
+}
+
+// newRecvPktConn returns a pktConn which reads its datagrams from mux,
+// or only uses the stream if mux is nil
+func newRecvPktConn(sess quic.Session, stream quic.Stream, mux *datagramMux) *pktConn {
+	c := newPktConn(sess, stream, mux != nil)
+	if mux != nil {
+		c.mux = mux
+		c.dgrams = mux.Register(c.id)
+	}
+	return c
+}
+
+// Close stops receiving datagrams, the stream is left to its owner
+func (c *pktConn) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		if c.mux != nil {
+			c.mux.Unregister(c.id)
+		}
+	})
+}
+
+func (c *pktConn) WritePkt(pkt []byte) error {
+	c.count(pkt, false)
+	return c.writePkt(pkt)
+}
+
+func (c *pktConn) writePkt(pkt []byte) error {
+	if c.datagram {
+		dgram := make([]byte, IDSIZE, IDSIZE+len(pkt))
+		binary.BigEndian.PutUint32(dgram, c.id)
+		return c.sess.SendDatagram(append(dgram, pkt...))
+	}
+
+	return c.writeStream(pkt)
+}
+
+// WritePiece is WritePkt for a coded piece, which is steered to a path if
+// the pktConn has a pathSteer. Redundant pieces are the ones sent beyond
+// what the client needs to decode.
+func (c *pktConn) WritePiece(pkt []byte, redundant bool) error {
+	c.count(pkt, redundant)
+	if !c.datagram || c.steer == nil {
+		return c.writePkt(pkt)
+	}
+
+	pathID, ok := c.steer.path(redundant)
+	if !ok {
+		return c.writePkt(pkt)
+	}
+
+	dgram := make([]byte, IDSIZE, IDSIZE+len(pkt))
+	binary.BigEndian.PutUint32(dgram, c.id)
+	return c.sess.SendDatagramOnPath(append(dgram, pkt...), pathID)
+}
+
+// WriteStreamPkt writes a frame which has to arrive, always on the stream
+func (c *pktConn) WriteStreamPkt(pkt []byte) error {
+	c.count(pkt, false)
+	return c.writeStream(pkt)
+}
+
+// count adds a frame to the server's metrics, if the pktConn has them
+func (c *pktConn) count(pkt []byte, redundant bool) {
+	if c.metrics != nil {
+		c.metrics.sent(pkt, redundant)
+	}
+}
+
+func (c *pktConn) writeStream(pkt []byte) error {
+	_, err := c.stream.Write(pkt)
+	if err != nil {
+		// the client resets the stream when it stops reading early
+		if err == io.EOF || strings.Contains(err.Error(), "closed stream") || strings.Contains(err.Error(), "RST_STREAM") {
+			return err
+		}
+		// Handle other errors that might not necessitate stopping.
+		fmt.Printf("Error writing to stream: %v\n", err)
+	}
+
+	return nil
+}
+
+// ReadPkt returns the next data frame, its size is taken from the header
+func (c *pktConn) ReadPkt() ([]byte, error) {
+	if !c.datagram {
+		return c.readStreamPkt()
+	}
+
+	c.streamOnce.Do(func() {
+		c.streamPkts = make(chan []byte)
+		go c.readStream()
+	})
+
+	select {
+	case dgram, ok := <-c.dgrams:
+		if !ok {
+			return nil, c.mux.Err()
+		}
+		return dgram, nil
+	case pkt, ok := <-c.streamPkts:
+		if ok {
+			return pkt, nil
+		}
+	}
+
+	// the server is done with the request once it closes the stream, hand
+	// out the datagrams which already arrived first
+	select {
+	case dgram, ok := <-c.dgrams:
+		if ok {
+			return dgram, nil
+		}
+	default:
+	}
+	return nil, c.streamErr
+}
+
+func (c *pktConn) readStreamPkt() ([]byte, error) {
+	header := make([]byte, HEADERSIZE)
+	if _, err := io.ReadFull(c.stream, header); err != nil {
+		return nil, err
+	}
+
+	size, err := FrameSize(header)
+	if err != nil {
+		return nil, err
+	}
+
+	pkt := make([]byte, size)
+	copy(pkt, header)
+	if _, err := io.ReadFull(c.stream, pkt[HEADERSIZE:]); err != nil {
+		return nil, err
+	}
+	return pkt, nil
+}
+
+// readStream forwards the frames of the stream to ReadPkt in datagram mode
+func (c *pktConn) readStream() {
+	defer close(c.streamPkts)
+
+	for {
+		pkt, err := c.readStreamPkt()
+		if err != nil {
+			c.streamErr = err
+			return
+		}
+
+		select {
+		case c.streamPkts <- pkt:
+		case <-c.closed:
+			c.streamErr = io.EOF
+			return
+		}
+	}
+}
+
+// datagramMux reads every datagram of a session and hands it to the
+// request whose stream id it is tagged with, so that several requests can
+// share one session. Datagrams of unknown requests, or of requests which
+// don't read fast enough, are dropped like the network would.
+type datagramMux struct {
+	sess    quic.Session
+	mutex   sync.Mutex
+	streams map[uint32]chan []byte
+	err     error
+}
+
+func newDatagramMux(sess quic.Session) *datagramMux {
+	m := &datagramMux{
+		sess:    sess,
+		streams: make(map[uint32]chan []byte),
+	}
+	go m.run()
+	return m
+}
+
+func (m *datagramMux) run() {
+	for {
+		dgram, err := m.sess.ReceiveDatagram()
+		if err != nil {
+			m.mutex.Lock()
+			m.err = err
+			for id, ch := range m.streams {
+				close(ch)
+				delete(m.streams, id)
+			}
+			m.mutex.Unlock()
+			return
+		}
+
+		if len(dgram) < IDSIZE+HEADERSIZE {
+			continue
+		}
+		id := binary.BigEndian.Uint32(dgram[:IDSIZE])
+
+		m.mutex.Lock()
+		if ch, ok := m.streams[id]; ok {
+			select {
+			case ch <- dgram[IDSIZE:]:
+			default:
+			}
+		}
+		m.mutex.Unlock()
+	}
+}
+
+// Register returns the channel the datagrams of stream id are sent on, it
+// is closed once the session fails
+func (m *datagramMux) Register(id uint32) <-chan []byte {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ch := make(chan []byte, maxInFlight*MAXPIECECOUNT)
+	if m.err != nil {
+		close(ch)
+		return ch
+	}
+	m.streams[id] = ch
+	return ch
+}
+
+func (m *datagramMux) Unregister(id uint32) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if ch, ok := m.streams[id]; ok {
+		close(ch)
+		delete(m.streams, id)
+	}
+}
+
+func (m *datagramMux) Err() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.err == nil {
+		return io.EOF
+	}
+	return m.err
+}
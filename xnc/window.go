@@ -0,0 +1,275 @@
+package xnc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/cloud9-tools/go-galoisfield"
+	"github.com/itzmeanjan/kodr"
+	"github.com/itzmeanjan/kodr/matrix"
+)
+
+// SlidingWindowEncoder codes a moving window of source pieces together
+// instead of independent generations. New pieces enter the window with
+// Push and leave it once the receiver acknowledges them with Advance, only
+// the pieces inside the window are held in memory.
+type SlidingWindowEncoder struct {
+	field *galoisfield.GF
+	// returns source piece i
+	load      func(i uint) (kodr.Piece, error)
+	count     uint
+	pieceSize int
+	window    uint
+	start     uint
+	end       uint
+	// pieces [start, end)
+	pieces []kodr.Piece
+	err    error
+}
+
+func NewSlidingWindowEncoder(pieces []kodr.Piece, window uint) *SlidingWindowEncoder {
+	load := func(i uint) (kodr.Piece, error) {
+		return pieces[i], nil
+	}
+
+	pieceSize := 0
+	if len(pieces) > 0 {
+		pieceSize = len(pieces[0])
+	}
+
+	return &SlidingWindowEncoder{field: galoisfield.DefaultGF256, load: load, count: uint(len(pieces)), pieceSize: pieceSize, window: window}
+}
+
+// Splits data into pieceSize pieces, padding the last one with zeros
+func NewSlidingWindowEncoderWithPieceSize(data []byte, pieceSize int, window uint) *SlidingWindowEncoder {
+	return NewSlidingWindowEncoderFromReader(bytes.NewReader(data), int64(len(data)), pieceSize, window)
+}
+
+// NewSlidingWindowEncoderFromReader reads the size bytes of r in pieceSize
+// pieces as they enter the window, padding the last one with zeros
+func NewSlidingWindowEncoderFromReader(r io.ReaderAt, size int64, pieceSize int, window uint) *SlidingWindowEncoder {
+	count := uint((size + int64(pieceSize) - 1) / int64(pieceSize))
+
+	load := func(i uint) (kodr.Piece, error) {
+		off := int64(i) * int64(pieceSize)
+		n := pieceSize
+		if remain := size - off; remain < int64(n) {
+			n = int(remain)
+		}
+
+		piece := make(kodr.Piece, pieceSize)
+		if read, err := r.ReadAt(piece[:n], off); read < n {
+			return nil, fmt.Errorf("Error reading piece %d: %v\n", i, err)
+		}
+		return piece, nil
+	}
+
+	return &SlidingWindowEncoder{field: galoisfield.DefaultGF256, load: load, count: count, pieceSize: pieceSize, window: window}
+}
+
+func (e *SlidingWindowEncoder) PieceCount() uint {
+	return e.count
+}
+
+// Window returns the range [start, end) of pieces currently coded together
+func (e *SlidingWindowEncoder) Window() (uint, uint) {
+	return e.start, e.end
+}
+
+// Push adds the next source piece to the window, returns false if the
+// window is full, every piece has already been added or the piece could
+// not be read (see Err)
+func (e *SlidingWindowEncoder) Push() bool {
+	if e.err != nil || e.end >= e.PieceCount() || e.end-e.start >= e.window {
+		return false
+	}
+
+	piece, err := e.load(e.end)
+	if err != nil {
+		e.err = err
+		return false
+	}
+
+	e.pieces = append(e.pieces, piece)
+	e.end++
+	return true
+}
+
+// Err returns the error which stopped Push, if any
+func (e *SlidingWindowEncoder) Err() error {
+	return e.err
+}
+
+// Advance drops every piece before next from the window, the receiver
+// has them all in order
+func (e *SlidingWindowEncoder) Advance(next uint) {
+	if next > e.end {
+		next = e.end
+	}
+	if next > e.start {
+		e.pieces = e.pieces[next-e.start:]
+		e.start = next
+	}
+}
+
+// CodedPiece returns a random combination of the pieces in the window along
+// with the window start. The coding vector is always `window` bytes long,
+// coefficients past the end of the window are zero.
+func (e *SlidingWindowEncoder) CodedPiece() (uint, *kodr.CodedPiece) {
+	vector := make(kodr.CodingVector, e.window)
+	copy(vector, kodr.GenerateCodingVector(e.end-e.start))
+
+	piece := make(kodr.Piece, e.pieceSize)
+	for i := range e.pieces {
+		piece.Multiply(e.pieces[i], vector[i], e.field)
+	}
+
+	return e.start, &kodr.CodedPiece{
+		Vector: vector,
+		Piece:  piece,
+	}
+}
+
+// SlidingWindowDecoder decodes pieces coded by SlidingWindowEncoder and
+// hands them back in order as soon as they're solvable, without waiting
+// for a whole generation.
+//
+// The decoder state only spans `window` columns starting at the first
+// piece not yet delivered, delivered pieces are kept for one more window
+// so that coded pieces which still include them can be reduced.
+type SlidingWindowDecoder struct {
+	field      *galoisfield.GF
+	state      *matrix.DecoderState
+	pieceCount uint
+	window     uint
+	base       uint
+	seen       uint
+	received   uint
+	history    []kodr.Piece
+}
+
+func NewSlidingWindowDecoder(pieceCount uint, window uint) *SlidingWindowDecoder {
+	gf := galoisfield.DefaultGF256
+	state := matrix.NewDecoderStateWithPieceCount(gf, window)
+	return &SlidingWindowDecoder{field: gf, state: state, pieceCount: pieceCount, window: window}
+}
+
+// Next is the index of the first piece not yet delivered
+func (d *SlidingWindowDecoder) Next() uint {
+	return d.base
+}
+
+func (d *SlidingWindowDecoder) IsDecoded() bool {
+	return d.base >= d.pieceCount
+}
+
+func (d *SlidingWindowDecoder) GetRecv() uint {
+	return d.received
+}
+
+// rank is the number of pieces delivered or held by the decoder, it only
+// grows with innovative pieces
+func (d *SlidingWindowDecoder) rank() uint {
+	return d.base + d.state.Rank()
+}
+
+// Required is the number of innovative pieces still missing to deliver
+// everything up to the end of the latest window seen
+func (d *SlidingWindowDecoder) Required() uint {
+	if d.seen <= d.base {
+		return 0
+	}
+	return d.seen - d.base - d.state.Rank()
+}
+
+// AddPiece adds a coded piece whose window starts at piece `start` and spans
+// `length` pieces, returns the pieces which became deliverable in order
+func (d *SlidingWindowDecoder) AddPiece(start uint, length uint, piece *kodr.CodedPiece) ([]kodr.Piece, error) {
+	if d.IsDecoded() {
+		return nil, kodr.ErrAllUsefulPiecesReceived
+	}
+	if length > d.window || uint(len(piece.Vector)) < length {
+		return nil, fmt.Errorf("window length %d is not correct\n", length)
+	}
+	if start > d.base {
+		return nil, fmt.Errorf("window start %d is ahead of next piece %d\n", start, d.base)
+	}
+
+	d.received++
+	if start+length > d.seen {
+		d.seen = start + length
+	}
+	if start+length <= d.base {
+		// every piece in the window was already delivered
+		return nil, nil
+	}
+
+	coded := make(kodr.Piece, len(piece.Piece))
+	copy(coded, piece.Piece)
+
+	// remove the pieces which were already delivered
+	for i := start; i < d.base; i++ {
+		coef := piece.Vector[i-start]
+		if coef == 0 {
+			continue
+		}
+
+		old := len(d.history) - int(d.base-i)
+		if old < 0 {
+			return nil, fmt.Errorf("piece %d is no longer in decoder history\n", i)
+		}
+		coded.Multiply(d.history[old], coef, d.field)
+	}
+
+	vector := make(kodr.CodingVector, d.window)
+	copy(vector, piece.Vector[d.base-start:length])
+
+	d.state.AddPiece(&kodr.CodedPiece{Vector: vector, Piece: coded})
+	d.state.Rref()
+
+	return d.deliver(), nil
+}
+
+// deliver pops every leading row which is solved down to a single piece
+func (d *SlidingWindowDecoder) deliver() []kodr.Piece {
+	delivered := make([]kodr.Piece, 0)
+
+	coeffs := d.state.CoefficientMatrix()
+	coded := d.state.CodedPieceMatrix()
+
+	for len(coeffs) > 0 && d.base < d.pieceCount && isUnit(coeffs[0]) {
+		delivered = append(delivered, coded[0])
+
+		d.history = append(d.history, coded[0])
+		if uint(len(d.history)) > d.window {
+			d.history = d.history[1:]
+		}
+		d.base++
+
+		coeffs = coeffs[1:]
+		coded = coded[1:]
+		for i := range coeffs {
+			coeffs[i] = append(coeffs[i][1:], 0)
+		}
+	}
+
+	if len(delivered) > 0 {
+		d.state = matrix.NewDecoderState(d.field, coeffs, coded)
+	}
+
+	return delivered
+}
+
+// whether vector is 1 at first position and 0 everywhere else
+func isUnit(vector []byte) bool {
+	if vector[0] != 1 {
+		return false
+	}
+	for _, v := range vector[1:] {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
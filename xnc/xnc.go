@@ -1,11 +1,12 @@
 package xnc
 
 import (
+	"crypto/sha512"
 	"encoding/binary"
 	"fmt"
+	"math"
 
 	"github.com/itzmeanjan/kodr"
-	"github.com/itzmeanjan/kodr/full"
 )
 
 // 8192 bits = 1024 bytes
@@ -15,34 +16,70 @@ var TYPE_INIT byte = 0x4
 var TYPE_XNC_ENC byte = 0x5
 var TYPE_XNC byte = 0x6
 var TYPE_END byte = 0x7
+var TYPE_INIT_SW byte = 0xc
+var TYPE_XNC_SW byte = 0xd
+var TYPE_INFO byte = 0xe
+var TYPE_DIGEST byte = 0xf
+
+// systematic coding, the first PieceCount pieces of a chunk go out uncoded
+var TYPE_INIT_SYS byte = 0x10
+var TYPE_XNC_SYS byte = 0x11
+
+// coded piece whose coefficients are generated from a seed, see SeedVector
+var TYPE_XNC_SEED byte = 0x12
+
+// chunks a resumed request already has, sent right after XNC_INIT
+var TYPE_RESUME byte = 0x13
+
+// XNC_INFO status, sent by the server before any data frame
+var STATUS_OK byte = 0x0
+var STATUS_NOT_FOUND byte = 0x1
+var STATUS_FORBIDDEN byte = 0x2
+var STATUS_INTERNAL byte = 0x3
+var STATUS_BAD_REQUEST byte = 0x4
+
+// XNC_INIT flags
+var INITFLAG_DATAGRAM byte = 0x1
+var INITFLAG_DIGEST byte = 0x2
+var INITFLAG_SEED byte = 0x4
+var INITFLAG_RESUME byte = 0x8
+
+// feedback sent from the client back to the server
+var TYPE_ACK_RANK byte = 0x8
+var TYPE_ACK_DECODED byte = 0x9
+var TYPE_ACK_MORE byte = 0xa
+var TYPE_ACK_ABORT byte = 0xb
 
 var TYPESIZE int = 1
 var IDSIZE int = 4
 var NUMSIZE int = 4
 var FILESIZESIZE int = 4
+var SEQSIZE int = 4
+var PIECECNTSIZE int = 4
+var PIECELENSIZE int = 4
 
-var VECTORSIZE int = CHUNKSIZE / 1024
-var PIECESIZE int = CHUNKSIZE / VECTORSIZE
-
-var FRAMESIZE_ENC int = TYPESIZE + IDSIZE + NUMSIZE + FILESIZESIZE + VECTORSIZE + PIECESIZE
-var FRAMESIZE int = TYPESIZE + IDSIZE + NUMSIZE + FILESIZESIZE + PIECESIZE
+// data frame header, followed by the coding vector (coded frames only) or
+// the seed (seeded frames only) and the piece
+var HEADERSIZE int = TYPESIZE + IDSIZE + NUMSIZE + FILESIZESIZE + SEQSIZE + PIECECNTSIZE + PIECELENSIZE
 var INITSIZE int = 128
-var INFOSIZE int = TYPESIZE + 4 + 4
-
-var (
-	PIECECNT      uint = uint(VECTORSIZE)
-	CODEDPIECECNT uint = PIECECNT + 1
-	// loss debug
-	// CODEDPIECECNT uint = PIECECNT*2
-)
-
-func GetXNCPkt(size int, id int, chunknum int, codepiece []byte) ([]byte, error) {
+var OFFSETSIZE int = 8
+var SEEDSIZE int = 8
+var VERSIONSIZE int = 8
+var DIGESTSIZE int = sha512.Size224
+var INITHEADERSIZE int = TYPESIZE + 1 + 4 + 4 + 2*OFFSETSIZE + 2 + 1 + 4
+var INFOSIZE int = TYPESIZE + 1 + NUMSIZE + OFFSETSIZE + VERSIONSIZE
+var ACKSIZE int = TYPESIZE + IDSIZE + 4 + 4 + SEQSIZE
+var RESUMEHEADERSIZE int = TYPESIZE + OFFSETSIZE + NUMSIZE + VERSIONSIZE
+
+func GetXNCPkt(size int, id int, chunknum int, seq int, piececount int, codepiece []byte) ([]byte, error) {
 	xncE := XNC{
-		Type:      TYPE_XNC,
-		ChunkId:   id,
-		ChunkSize: size,
-		ChunkNum:  chunknum,
-		Piece:     codepiece,
+		Type:       TYPE_XNC,
+		ChunkId:    id,
+		ChunkSize:  size,
+		ChunkNum:   chunknum,
+		Seq:        seq,
+		PieceCount: piececount,
+		Piece:      codepiece,
 	}
 
 	pktE, err := EncodeXNCPkt(xncE)
@@ -54,7 +91,17 @@ func GetXNCPkt(size int, id int, chunknum int, codepiece []byte) ([]byte, error)
 	return pktE, nil
 }
 
-func GetXNCEncPkt(size int, id int, chunknum int, codepiece *kodr.CodedPiece) ([]byte, error) {
+func GetXNCEncPkt(size int, id int, chunknum int, seq int, codepiece *kodr.CodedPiece) ([]byte, error) {
+	return getCodedPkt(TYPE_XNC_ENC, size, id, chunknum, seq, codepiece)
+}
+
+// GetXNCSysPkt is GetXNCEncPkt for a piece of a systematic encoder, which
+// is either uncoded or a repair piece
+func GetXNCSysPkt(size int, id int, chunknum int, seq int, codepiece *kodr.CodedPiece) ([]byte, error) {
+	return getCodedPkt(TYPE_XNC_SYS, size, id, chunknum, seq, codepiece)
+}
+
+func getCodedPkt(pktType byte, size int, id int, chunknum int, seq int, codepiece *kodr.CodedPiece) ([]byte, error) {
 	vec := make([]byte, 0)
 	piece := make([]byte, 0)
 
@@ -62,12 +109,14 @@ func GetXNCEncPkt(size int, id int, chunknum int, codepiece *kodr.CodedPiece) ([
 	piece = append(piece, codepiece.Piece...)
 
 	xncE := XNC{
-		Type:      TYPE_XNC_ENC,
-		ChunkId:   id,
-		ChunkSize: size,
-		ChunkNum:  chunknum,
-		Vector:    vec,
-		Piece:     piece,
+		Type:       pktType,
+		ChunkId:    id,
+		ChunkSize:  size,
+		ChunkNum:   chunknum,
+		Seq:        seq,
+		PieceCount: len(vec),
+		Vector:     vec,
+		Piece:      piece,
 	}
 
 	pktE, err := EncodeXNCPkt(xncE)
@@ -79,7 +128,59 @@ func GetXNCEncPkt(size int, id int, chunknum int, codepiece *kodr.CodedPiece) ([
 	return pktE, nil
 }
 
-func GetFile(decoder *full.FullRLNCDecoder) ([]byte, error) {
+// GetXNCSeedPkt builds a seeded frame, its coefficients are
+// SeedVector(seed, piececount)
+func GetXNCSeedPkt(size int, id int, chunknum int, seq int, piececount int, seed uint64, codepiece []byte) ([]byte, error) {
+	xncE := XNC{
+		Type:       TYPE_XNC_SEED,
+		ChunkId:    id,
+		ChunkSize:  size,
+		ChunkNum:   chunknum,
+		Seq:        seq,
+		PieceCount: piececount,
+		Seed:       seed,
+		Piece:      codepiece,
+	}
+
+	pktE, err := EncodeXNCPkt(xncE)
+	if err != nil {
+		return nil, fmt.Errorf("Error encoding packet data: %v", err)
+	}
+
+	return pktE, nil
+}
+
+func GetXNCSWPkt(filesize int, start uint, length uint, seq int, codepiece *kodr.CodedPiece) ([]byte, error) {
+	xncE := XNC{
+		Type:       TYPE_XNC_SW,
+		ChunkId:    int(start),
+		ChunkSize:  filesize,
+		ChunkNum:   int(length),
+		Seq:        seq,
+		PieceCount: len(codepiece.Vector),
+		Vector:     codepiece.Vector,
+		Piece:      codepiece.Piece,
+	}
+
+	pktE, err := EncodeXNCPkt(xncE)
+	if err != nil {
+		return nil, fmt.Errorf("Error encoding packet data: %v", err)
+	}
+
+	return pktE, nil
+}
+
+// ChunkDecoder decodes the pieces of one chunk, either a
+// full.FullRLNCDecoder or a SystematicDecoder
+type ChunkDecoder interface {
+	AddPiece(piece *kodr.CodedPiece) error
+	IsDecoded() bool
+	Required() uint
+	GetRecv() uint
+	GetPieces() ([]kodr.Piece, error)
+}
+
+func GetFile(decoder ChunkDecoder) ([]byte, error) {
 	dec_p, err := decoder.GetPieces()
 	if err != nil {
 		return nil, fmt.Errorf("Error getting pieces: %v", err)
@@ -95,83 +196,298 @@ func GetFile(decoder *full.FullRLNCDecoder) ([]byte, error) {
 	return recvfile, nil
 }
 
+// XNC is a data frame. PieceCount is the number of pieces per chunk the
+// request negotiated, coded frames carry a Vector of that length, and every
+// piece is len(Piece) bytes. In sliding window mode (TYPE_XNC_SW) ChunkId
+// is the first piece of the coding window, ChunkNum the window length and
+// ChunkSize the size of the whole file. Seeded frames (TYPE_XNC_SEED) carry
+// the Seed of their coefficients instead of a Vector.
 type XNC struct {
-	Type      byte
-	ChunkId   int
-	ChunkNum  int
-	ChunkSize int
-	Vector    []byte
-	Piece     []byte
-	End       bool
+	Type       byte
+	ChunkId    int
+	ChunkNum   int
+	ChunkSize  int
+	Seq        int
+	PieceCount int
+	Vector     []byte
+	Seed       uint64
+	Piece      []byte
+	End        bool
 }
 
+// XNC_ACK is the client's per-chunk feedback, one of
+//
+//	TYPE_ACK_RANK:    decoder rank so far after receiving piece Seq
+//	TYPE_ACK_DECODED: chunk decoded, no more pieces wanted
+//	TYPE_ACK_MORE:    ask for Required more pieces right away, after END
+//	                  also for a chunk the server saw decoded
+//	TYPE_ACK_ABORT:   client gives up on the transfer
+//
+// In sliding window mode ChunkId is the next piece the client needs in
+// order and TYPE_ACK_DECODED is only sent once the whole file is delivered.
+//
+// Required is the number of innovative pieces still missing, Received the
+// number of pieces handed to the decoder and Seq the highest piece
+// sequence number seen for the chunk.
 type XNC_ACK struct {
-	Type    byte
-	ChunkId int
+	Type     byte
+	ChunkId  int
+	Required int
+	Received int
+	Seq      int
 }
 
+// XNC_INIT is the client's request. ChunkSize and PieceCount are the coding
+// parameters the client wants the server to use for this file. Only the
+// Length bytes from Offset are sent, a zero Length means up to the end of
+// the file. Window is the number of chunks the client keeps open at once,
+// the server doesn't start a chunk Window or more after the first one the
+// client hasn't decoded yet. Source numbers the servers a client fetches
+// the file from at once, each of them codes with its own coefficients.
 type XNC_INIT struct {
-	Type     byte
-	Len      int
-	Filename string
+	Type       byte
+	Flags      byte
+	ChunkSize  int
+	PieceCount int
+	Offset     int64
+	Length     int64
+	Window     int
+	Source     int
+	Len        int
+	Filename   string
 }
 
+// XNC_INFO answers a request on its stream before any data frame. ChunkNum,
+// FileSize and Version are only set with STATUS_OK, ChunkNum counts chunks
+// of the requested chunk size and Version changes whenever the file does.
 type XNC_INFO struct {
 	Type     byte
+	Status   byte
 	ChunkNum int
 	FileSize int
+	Version  int64
 }
 
 func EncodeInfo(data XNC_INFO) ([]byte, error) {
-	pkt := []byte{}
+	if data.Type != TYPE_INFO {
+		return nil, fmt.Errorf("info type is not correct\n")
+	}
+	if data.Status > STATUS_BAD_REQUEST {
+		return nil, fmt.Errorf("info status %d is not correct\n", data.Status)
+	}
+	if data.ChunkNum < 0 || data.ChunkNum > math.MaxUint32 || data.FileSize < 0 {
+		return nil, fmt.Errorf("info of %d chunks in %d bytes is not correct\n", data.ChunkNum, data.FileSize)
+	}
+
+	pkt := make([]byte, INFOSIZE)
+
+	pkt[0] = data.Type
+	pkt[1] = data.Status
+	binary.BigEndian.PutUint32(pkt[2:6], uint32(data.ChunkNum))
+	binary.BigEndian.PutUint64(pkt[6:14], uint64(data.FileSize))
+	binary.BigEndian.PutUint64(pkt[14:22], uint64(data.Version))
+
+	return pkt, nil
+}
+
+func DecodeInfo(pkt []byte) (XNC_INFO, error) {
+	if len(pkt) != INFOSIZE {
+		return XNC_INFO{}, fmt.Errorf("info len %d is not correct\n", len(pkt))
+	}
+
+	if pkt[0] != TYPE_INFO {
+		return XNC_INFO{}, fmt.Errorf("pkt type is not correct\n")
+	}
+
+	info := XNC_INFO{}
+	info.Type = pkt[0]
+	info.Status = pkt[1]
+	info.ChunkNum = int(binary.BigEndian.Uint32(pkt[2:6]))
+	info.FileSize = int(binary.BigEndian.Uint64(pkt[6:14]))
+	info.Version = int64(binary.BigEndian.Uint64(pkt[14:22]))
+
+	return info, nil
+}
+
+// XNC_RESUME follows the XNC_INIT of a request with INITFLAG_RESUME. It
+// lists the chunks the client decoded before its last session dropped, as
+// a bitmap of ChunkNum bits, and the server codes only the others. FileSize,
+// ChunkNum and Version are the ones of the interrupted request, a server
+// whose file changed since ignores Done.
+type XNC_RESUME struct {
+	Type     byte
+	FileSize int
+	ChunkNum int
+	Version  int64
+	Done     []bool
+}
 
-	pkt = append(pkt, data.Type)
+func EncodeResume(data XNC_RESUME) ([]byte, error) {
+	if data.Type != TYPE_RESUME {
+		return nil, fmt.Errorf("resume type is not correct\n")
+	}
+	if data.FileSize < 0 || data.ChunkNum < 0 || data.ChunkNum > math.MaxUint32 || len(data.Done) != data.ChunkNum {
+		return nil, fmt.Errorf("resume of %d chunks in %d bytes is not correct\n", len(data.Done), data.FileSize)
+	}
 
-	chunkNum := make([]byte, 4)
-	binary.BigEndian.PutUint32(chunkNum, uint32(data.ChunkNum))
-	pkt = append(pkt, chunkNum...)
+	pkt := make([]byte, RESUMEHEADERSIZE+(data.ChunkNum+7)/8)
 
-	fileSize := make([]byte, 4)
-	binary.BigEndian.PutUint32(fileSize, uint32(data.FileSize))
-	pkt = append(pkt, fileSize...)
+	pkt[0] = data.Type
+	binary.BigEndian.PutUint64(pkt[1:9], uint64(data.FileSize))
+	binary.BigEndian.PutUint32(pkt[9:13], uint32(data.ChunkNum))
+	binary.BigEndian.PutUint64(pkt[13:21], uint64(data.Version))
+
+	for i, done := range data.Done {
+		if done {
+			pkt[RESUMEHEADERSIZE+i/8] |= 0x80 >> (i % 8)
+		}
+	}
 
 	return pkt, nil
 }
 
+// ResumeSize returns the size of the whole resume frame starting with
+// header, which has to hold at least RESUMEHEADERSIZE bytes
+func ResumeSize(header []byte) (int, error) {
+	if len(header) < RESUMEHEADERSIZE {
+		return 0, fmt.Errorf("resume header size %d is not correct\n", len(header))
+	}
+
+	if header[0] != TYPE_RESUME {
+		return 0, fmt.Errorf("pkt type is not correct\n")
+	}
+
+	chunkNum := int64(binary.BigEndian.Uint32(header[9:13]))
+	return RESUMEHEADERSIZE + int((chunkNum+7)/8), nil
+}
+
+func DecodeResume(pkt []byte) (XNC_RESUME, error) {
+	size, err := ResumeSize(pkt)
+	if err != nil {
+		return XNC_RESUME{}, err
+	}
+	if len(pkt) != size {
+		return XNC_RESUME{}, fmt.Errorf("resume len %d is not correct\n", len(pkt))
+	}
+
+	resume := XNC_RESUME{}
+	resume.Type = pkt[0]
+	resume.FileSize = int(binary.BigEndian.Uint64(pkt[1:9]))
+	resume.ChunkNum = int(binary.BigEndian.Uint32(pkt[9:13]))
+	resume.Version = int64(binary.BigEndian.Uint64(pkt[13:21]))
+	resume.Done = make([]bool, resume.ChunkNum)
+	for i := range resume.Done {
+		resume.Done[i] = pkt[RESUMEHEADERSIZE+i/8]&(0x80>>(i%8)) != 0
+	}
+
+	return resume, nil
+}
+
+func IsACK(t byte) bool {
+	return t == TYPE_ACK_RANK || t == TYPE_ACK_DECODED || t == TYPE_ACK_MORE || t == TYPE_ACK_ABORT
+}
+
+func EncodeACK(data XNC_ACK) ([]byte, error) {
+	if !IsACK(data.Type) {
+		return nil, fmt.Errorf("ack type is not correct\n")
+	}
+
+	pkt := make([]byte, ACKSIZE)
+
+	pkt[0] = data.Type
+	binary.BigEndian.PutUint32(pkt[1:5], uint32(data.ChunkId))
+	binary.BigEndian.PutUint32(pkt[5:9], uint32(data.Required))
+	binary.BigEndian.PutUint32(pkt[9:13], uint32(data.Received))
+	binary.BigEndian.PutUint32(pkt[13:17], uint32(data.Seq))
+
+	return pkt, nil
+}
+
+func DecodeACK(pkt []byte) (XNC_ACK, error) {
+	if len(pkt) != ACKSIZE {
+		return XNC_ACK{}, fmt.Errorf("ack len %d is not correct\n", len(pkt))
+	}
+
+	if !IsACK(pkt[0]) {
+		return XNC_ACK{}, fmt.Errorf("pkt type is not correct\n")
+	}
+
+	ack := XNC_ACK{}
+	ack.Type = pkt[0]
+	ack.ChunkId = int(binary.BigEndian.Uint32(pkt[1:5]))
+	ack.Required = int(binary.BigEndian.Uint32(pkt[5:9]))
+	ack.Received = int(binary.BigEndian.Uint32(pkt[9:13]))
+	ack.Seq = int(binary.BigEndian.Uint32(pkt[13:17]))
+
+	return ack, nil
+}
+
 func EncodeInit(data XNC_INIT) ([]byte, error) {
-	pkt := make([]byte, 128)
+	if data.Len != len(data.Filename) || data.Len > INITSIZE-INITHEADERSIZE {
+		return nil, fmt.Errorf("init filename len %d is not correct\n", data.Len)
+	}
+	if data.Offset < 0 || data.Length < 0 {
+		return nil, fmt.Errorf("init range %d+%d is not correct\n", data.Offset, data.Length)
+	}
+	if data.Window < 0 || data.Window > math.MaxUint16 {
+		return nil, fmt.Errorf("init window %d is not correct\n", data.Window)
+	}
+	if data.Source < 0 || data.Source > math.MaxUint8 {
+		return nil, fmt.Errorf("init source %d is not correct\n", data.Source)
+	}
+
+	pkt := make([]byte, INITSIZE)
 
 	pkt[0] = data.Type
-	binary.BigEndian.PutUint32(pkt[1:5], uint32(data.Len))
+	pkt[1] = data.Flags
+	binary.BigEndian.PutUint32(pkt[2:6], uint32(data.ChunkSize))
+	binary.BigEndian.PutUint32(pkt[6:10], uint32(data.PieceCount))
+	binary.BigEndian.PutUint64(pkt[10:18], uint64(data.Offset))
+	binary.BigEndian.PutUint64(pkt[18:26], uint64(data.Length))
+	binary.BigEndian.PutUint16(pkt[26:28], uint16(data.Window))
+	pkt[28] = byte(data.Source)
+	binary.BigEndian.PutUint32(pkt[29:33], uint32(data.Len))
 
 	for i := 0; i < data.Len; i++ {
-		pkt[5+i] = data.Filename[i]
+		pkt[INITHEADERSIZE+i] = data.Filename[i]
 	}
 
 	return pkt, nil
 }
 
 func DecodeInit(data []byte) (XNC_INIT, error) {
-	if data[0] != TYPE_INIT_ENC && data[0] != TYPE_INIT {
+	if len(data) < INITHEADERSIZE {
+		return XNC_INIT{}, fmt.Errorf("init len %d is not correct\n", len(data))
+	}
+
+	if data[0] != TYPE_INIT_ENC && data[0] != TYPE_INIT && data[0] != TYPE_INIT_SW && data[0] != TYPE_INIT_SYS {
 		return XNC_INIT{}, fmt.Errorf("pkt type is not correct\n")
 	}
 
 	init := XNC_INIT{}
 	init.Type = data[0]
-	init.Len = int(binary.BigEndian.Uint32(data[1:5]))
-	init.Filename = string(data[5 : 5+init.Len])
+	init.Flags = data[1]
+	init.ChunkSize = int(binary.BigEndian.Uint32(data[2:6]))
+	init.PieceCount = int(binary.BigEndian.Uint32(data[6:10]))
+	init.Offset = int64(binary.BigEndian.Uint64(data[10:18]))
+	init.Length = int64(binary.BigEndian.Uint64(data[18:26]))
+	if init.Offset < 0 || init.Length < 0 {
+		return XNC_INIT{}, fmt.Errorf("init range %d+%d is not correct\n", init.Offset, init.Length)
+	}
+	init.Window = int(binary.BigEndian.Uint16(data[26:28]))
+	init.Source = int(data[28])
+	init.Len = int(binary.BigEndian.Uint32(data[29:33]))
+	if init.Len > len(data)-INITHEADERSIZE {
+		return XNC_INIT{}, fmt.Errorf("init filename len %d is not correct\n", init.Len)
+	}
+	init.Filename = string(data[INITHEADERSIZE : INITHEADERSIZE+init.Len])
 
 	return init, nil
 }
 
-func EncodeEND(id int, encode bool) []byte {
-	var pkt []byte
-
-	if encode {
-		pkt = make([]byte, FRAMESIZE_ENC)
-	} else {
-		pkt = make([]byte, FRAMESIZE)
-	}
+// EncodeEND builds an END frame, a bare header without vector or piece
+func EncodeEND(id int) []byte {
+	pkt := make([]byte, HEADERSIZE)
 
 	pkt[0] = TYPE_END
 	binary.BigEndian.PutUint32(pkt[1:5], uint32(id))
@@ -179,12 +495,9 @@ func EncodeEND(id int, encode bool) []byte {
 	return pkt
 }
 
-func DecodeEND(pkt []byte, encode bool) (int, error) {
-	if encode && len(pkt) != FRAMESIZE_ENC {
-		return -1, fmt.Errorf("enc ack len is not correct\n")
-	} else if !encode && len(pkt) != FRAMESIZE {
-		return -1, fmt.Errorf("ack len is not correct\n")
-
+func DecodeEND(pkt []byte) (int, error) {
+	if len(pkt) != HEADERSIZE {
+		return -1, fmt.Errorf("end len is not correct\n")
 	}
 
 	if pkt[0] != TYPE_END {
@@ -196,41 +509,117 @@ func DecodeEND(pkt []byte, encode bool) (int, error) {
 	return id, nil
 }
 
-func EncodeXNCPkt(data XNC) ([]byte, error) {
-	if data.Type == TYPE_XNC_ENC && (len(data.Vector) != VECTORSIZE || len(data.Piece) != PIECESIZE) {
-		return nil, fmt.Errorf("XNC Vector %d or Piece %d size is not correct\n", len(data.Vector), len(data.Piece))
-	} else if data.Type == TYPE_XNC && len(data.Piece) != PIECESIZE {
-		return nil, fmt.Errorf("XNC pkt byte size is not correct\n")
+// EncodeDigest builds a DIGEST frame, the SHA-512/224 digest of chunk id
+// or, with id equal to the chunk count, of the whole file
+func EncodeDigest(id int, digest []byte) ([]byte, error) {
+	if len(digest) != DIGESTSIZE {
+		return nil, fmt.Errorf("digest len %d is not correct\n", len(digest))
+	}
+
+	pkt := make([]byte, HEADERSIZE, HEADERSIZE+DIGESTSIZE)
+
+	pkt[0] = TYPE_DIGEST
+	binary.BigEndian.PutUint32(pkt[1:5], uint32(id))
+
+	return append(pkt, digest...), nil
+}
+
+func DecodeDigest(pkt []byte) (int, []byte, error) {
+	if len(pkt) != HEADERSIZE+DIGESTSIZE {
+		return -1, nil, fmt.Errorf("digest len is not correct\n")
+	}
+
+	if pkt[0] != TYPE_DIGEST {
+		return -1, nil, fmt.Errorf("pkt type is not correct\n")
+	}
+
+	id := int(binary.BigEndian.Uint32(pkt[1:5]))
+
+	return id, pkt[HEADERSIZE:], nil
+}
+
+func isCoded(t byte) bool {
+	return t == TYPE_XNC_ENC || t == TYPE_XNC_SW || t == TYPE_XNC_SYS
+}
+
+// maxPieceCount is the largest piece count a frame of type t can carry,
+// frames without a coding vector allow more pieces
+func maxPieceCount(t byte) int {
+	if isCoded(t) {
+		return MAXPIECECOUNT
 	}
+	return MAXSEEDPIECECOUNT
+}
 
-	pkt := make([]byte, 0, FRAMESIZE_ENC)
+// FrameSize returns the size of the whole data frame starting with header,
+// which has to hold at least HEADERSIZE bytes
+func FrameSize(header []byte) (int, error) {
+	if len(header) < HEADERSIZE {
+		return 0, fmt.Errorf("XNC header size %d is not correct\n", len(header))
+	}
 
-	pkt = append(pkt, data.Type)
+	if header[0] == TYPE_END {
+		return HEADERSIZE, nil
+	}
+	if header[0] == TYPE_DIGEST {
+		return HEADERSIZE + DIGESTSIZE, nil
+	}
+	if !isCoded(header[0]) && header[0] != TYPE_XNC && header[0] != TYPE_XNC_SEED {
+		return 0, fmt.Errorf("Unknow XNC type\n")
+	}
 
-	chunkId := make([]byte, 4)
-	binary.BigEndian.PutUint32(chunkId, uint32(data.ChunkId))
-	pkt = append(pkt, chunkId...)
+	pieceCount := int(binary.BigEndian.Uint32(header[17:21]))
+	pieceSize := int(binary.BigEndian.Uint32(header[21:25]))
+	if pieceCount <= 0 || pieceCount > maxPieceCount(header[0]) || pieceSize <= 0 || pieceSize > MAXCHUNKSIZE {
+		return 0, fmt.Errorf("XNC piece count %d or piece size %d is not correct\n", pieceCount, pieceSize)
+	}
 
-	pktsize := make([]byte, 4)
-	binary.BigEndian.PutUint32(pktsize, uint32(data.ChunkSize))
-	pkt = append(pkt, pktsize...)
+	if isCoded(header[0]) {
+		return HEADERSIZE + pieceCount + pieceSize, nil
+	}
+	if header[0] == TYPE_XNC_SEED {
+		return HEADERSIZE + SEEDSIZE + pieceSize, nil
+	}
+	return HEADERSIZE + pieceSize, nil
+}
+
+func EncodeXNCPkt(data XNC) ([]byte, error) {
+	coded := isCoded(data.Type)
+
+	if !coded && data.Type != TYPE_XNC && data.Type != TYPE_XNC_SEED {
+		return nil, fmt.Errorf("Unknow XNC type\n")
+	} else if data.PieceCount <= 0 || data.PieceCount > maxPieceCount(data.Type) || len(data.Piece) == 0 {
+		return nil, fmt.Errorf("XNC piece count %d or piece size %d is not correct\n", data.PieceCount, len(data.Piece))
+	} else if coded && len(data.Vector) != data.PieceCount {
+		return nil, fmt.Errorf("XNC Vector %d size is not correct\n", len(data.Vector))
+	}
 
-	chunknumsize := make([]byte, 4)
-	binary.BigEndian.PutUint32(chunknumsize, uint32(data.ChunkNum))
-	pkt = append(pkt, chunknumsize...)
+	pkt := make([]byte, HEADERSIZE, HEADERSIZE+len(data.Vector)+SEEDSIZE+len(data.Piece))
 
-	if data.Type == TYPE_XNC_ENC {
-		pkt = append(pkt, data.Vector[:VECTORSIZE]...)
-		pkt = append(pkt, data.Piece[:PIECESIZE]...)
-	} else if data.Type == TYPE_XNC {
-		pkt = append(pkt, data.Piece[:PIECESIZE]...)
+	pkt[0] = data.Type
+	binary.BigEndian.PutUint32(pkt[1:5], uint32(data.ChunkId))
+	binary.BigEndian.PutUint32(pkt[5:9], uint32(data.ChunkSize))
+	binary.BigEndian.PutUint32(pkt[9:13], uint32(data.ChunkNum))
+	binary.BigEndian.PutUint32(pkt[13:17], uint32(data.Seq))
+	binary.BigEndian.PutUint32(pkt[17:21], uint32(data.PieceCount))
+	binary.BigEndian.PutUint32(pkt[21:25], uint32(len(data.Piece)))
+
+	if coded {
+		pkt = append(pkt, data.Vector...)
+	} else if data.Type == TYPE_XNC_SEED {
+		pkt = binary.BigEndian.AppendUint64(pkt, data.Seed)
 	}
+	pkt = append(pkt, data.Piece...)
 
 	return pkt, nil
 }
 
 func DecodeXNCPkt(data []byte) (XNC, error) {
-	if len(data) != FRAMESIZE_ENC && len(data) != FRAMESIZE {
+	size, err := FrameSize(data)
+	if err != nil {
+		return XNC{}, err
+	}
+	if len(data) != size {
 		return XNC{}, fmt.Errorf("XNC pkt size %d is not correct\n", len(data))
 	}
 
@@ -244,14 +633,17 @@ func DecodeXNCPkt(data []byte) (XNC, error) {
 	xnc.ChunkId = int(binary.BigEndian.Uint32(data[1:5]))
 	xnc.ChunkSize = int(binary.BigEndian.Uint32(data[5:9]))
 	xnc.ChunkNum = int(binary.BigEndian.Uint32(data[9:13]))
-
-	if xnc.Type == TYPE_XNC_ENC {
-		xnc.Vector = data[13 : 13+VECTORSIZE]
-		xnc.Piece = data[13+VECTORSIZE : 13+VECTORSIZE+PIECESIZE]
-	} else if xnc.Type == TYPE_XNC {
-		xnc.Piece = data[13:]
+	xnc.Seq = int(binary.BigEndian.Uint32(data[13:17]))
+	xnc.PieceCount = int(binary.BigEndian.Uint32(data[17:21]))
+
+	if isCoded(xnc.Type) {
+		xnc.Vector = data[HEADERSIZE : HEADERSIZE+xnc.PieceCount]
+		xnc.Piece = data[HEADERSIZE+xnc.PieceCount:]
+	} else if xnc.Type == TYPE_XNC_SEED {
+		xnc.Seed = binary.BigEndian.Uint64(data[HEADERSIZE : HEADERSIZE+SEEDSIZE])
+		xnc.Piece = data[HEADERSIZE+SEEDSIZE:]
 	} else {
-		return XNC{}, fmt.Errorf("Unknow XNC type\n")
+		xnc.Piece = data[HEADERSIZE:]
 	}
 
 	return xnc, nil
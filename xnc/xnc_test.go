@@ -2,16 +2,27 @@ package xnc
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha512"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/itzmeanjan/kodr"
 	"github.com/itzmeanjan/kodr/full"
+	"github.com/itzmeanjan/kodr/systematic"
+	"github.com/lucas-clemente/quic-go"
+	quicproxy "github.com/lucas-clemente/quic-go/integrationtests/tools/proxy"
 )
 
 func TestWhole(t *testing.T) {
@@ -32,35 +43,39 @@ func TestWhole(t *testing.T) {
 
 	rFile := make([]byte, 0)
 
-	chunks := SpiltFile(filebytes, CHUNKSIZE)
+	conf := DefaultConfig()
+	pieceCount := uint(conf.PieceCount)
+	codedPieceCount := pieceCount + 1
+
+	chunks := SpiltFile(filebytes, conf.ChunkSize)
 
 	var size int
 	for i := 0; i < len(chunks); i++ {
 
 		if i == len(chunks)-1 {
-			size = len(filebytes) % CHUNKSIZE
+			size = len(filebytes) % conf.ChunkSize
 		} else {
-			size = CHUNKSIZE
+			size = conf.ChunkSize
 		}
 
 		hasher := sha512.New512_224()
 		hasher.Write(chunks[i])
 
-		enc, err := full.NewFullRLNCEncoderWithPieceCount(chunks[i], PIECECNT)
+		enc, err := full.NewFullRLNCEncoderWithPieceCount(chunks[i], pieceCount)
 		if err != nil {
 			log.Printf("Error: %s\n", err.Error())
 			return
 		}
 
-		codedPieces := make([]*kodr.CodedPiece, 0, CODEDPIECECNT)
-		for i := 0; i < int(CODEDPIECECNT); i++ {
+		codedPieces := make([]*kodr.CodedPiece, 0, codedPieceCount)
+		for i := 0; i < int(codedPieceCount); i++ {
 			codedPieces = append(codedPieces, enc.CodedPiece())
 		}
 
-		decoder := full.NewFullRLNCDecoder(PIECECNT)
+		decoder := full.NewFullRLNCDecoder(pieceCount)
 
-		for s := 0; s < int(CODEDPIECECNT); s++ {
-			pktE, err := GetXNCEncPkt(size, i, len(chunks), codedPieces[s])
+		for s := 0; s < int(codedPieceCount); s++ {
+			pktE, err := GetXNCEncPkt(size, i, len(chunks), s, codedPieces[s])
 			if err != nil {
 				t.Errorf("Error encoding packet data: %v", err)
 				return
@@ -113,11 +128,12 @@ func TestWhole(t *testing.T) {
 
 func TestOriginXNCPkt(t *testing.T) {
 	xnc := XNC{
-		ChunkId:   1,
-		Type:      byte(TYPE_XNC),
-		ChunkSize: 4,
-		ChunkNum:  10,
-		Piece:     make([]byte, PIECESIZE),
+		ChunkId:    1,
+		Type:       byte(TYPE_XNC),
+		ChunkSize:  4,
+		ChunkNum:   10,
+		PieceCount: DefaultPieceCount,
+		Piece:      make([]byte, DefaultChunkSize/DefaultPieceCount),
 	}
 
 	for i := range xnc.Piece {
@@ -146,9 +162,16 @@ func TestOriginXNCPkt(t *testing.T) {
 
 func TestInit(t *testing.T) {
 	init := XNC_INIT{
-		Type:     TYPE_INIT_ENC,
-		Len:      4,
-		Filename: "test",
+		Type:       TYPE_INIT_ENC,
+		Flags:      INITFLAG_DATAGRAM,
+		ChunkSize:  1 << 12,
+		PieceCount: 32,
+		Offset:     1 << 33,
+		Length:     12345,
+		Window:     24,
+		Source:     3,
+		Len:        4,
+		Filename:   "test",
 	}
 
 	encode, err := EncodeInit(init)
@@ -165,6 +188,18 @@ func TestInit(t *testing.T) {
 	if init.Type != decode.Type {
 		t.Errorf("Failed to decode xnc correctly.\nExpected: %v\nGot: %v", init.Type, decode.Type)
 	}
+	if init.Flags != decode.Flags {
+		t.Errorf("Failed to decode xnc correctly.\nExpected: %v\nGot: %v", init.Flags, decode.Flags)
+	}
+	if init.ChunkSize != decode.ChunkSize || init.PieceCount != decode.PieceCount {
+		t.Errorf("Failed to decode xnc correctly.\nExpected: %v, %v\nGot: %v, %v", init.ChunkSize, init.PieceCount, decode.ChunkSize, decode.PieceCount)
+	}
+	if init.Offset != decode.Offset || init.Length != decode.Length {
+		t.Errorf("Failed to decode xnc correctly.\nExpected: %v+%v\nGot: %v+%v", init.Offset, init.Length, decode.Offset, decode.Length)
+	}
+	if init.Window != decode.Window || init.Source != decode.Source {
+		t.Errorf("Failed to decode xnc correctly.\nExpected: %v, %v\nGot: %v, %v", init.Window, init.Source, decode.Window, decode.Source)
+	}
 	if init.Len != decode.Len {
 		t.Errorf("Failed to decode xnc correctly.\nExpected: %v\nGot: %v", init.Len, decode.Len)
 	}
@@ -172,54 +207,1975 @@ func TestInit(t *testing.T) {
 		t.Errorf("Failed to decode xnc correctly.\nExpected: %v\nGot: %v", init.Filename, decode.Filename)
 	}
 }
-func TestXNC(t *testing.T) {
-	xnc := XNC{
-		ChunkId:   1,
-		Type:      TYPE_XNC_ENC,
-		ChunkSize: 4,
-		ChunkNum:  10,
-		Vector:    make([]byte, VECTORSIZE),
-		Piece:     make([]byte, PIECESIZE),
+func TestACK(t *testing.T) {
+	for _, ackType := range []byte{TYPE_ACK_RANK, TYPE_ACK_DECODED, TYPE_ACK_MORE, TYPE_ACK_ABORT} {
+		ack := XNC_ACK{
+			Type:     ackType,
+			ChunkId:  3,
+			Required: 5,
+			Received: 12,
+			Seq:      13,
+		}
+
+		encode, err := EncodeACK(ack)
+		if err != nil {
+			t.Errorf("Error encode ack: %v", err)
+			return
+		}
+		decode, err := DecodeACK(encode)
+		if err != nil {
+			t.Errorf("Error decode ack: %v", err)
+			return
+		}
+
+		if ack != decode {
+			t.Errorf("Failed to decode ack correctly.\nExpected: %v\nGot: %v", ack, decode)
+		}
 	}
 
-	for i := range xnc.Vector {
-		xnc.Vector[i] = byte(i)
+	if _, err := EncodeACK(XNC_ACK{Type: TYPE_END}); err == nil {
+		t.Errorf("Expected error encoding ack with type %v", TYPE_END)
 	}
-	for i := range xnc.Piece {
-		xnc.Piece[i] = byte(i)
+}
+
+func TestLossEstimator(t *testing.T) {
+	est := newLossEstimator()
+	pieceCount := uint(DefaultPieceCount)
+
+	if extra := est.Redundancy(pieceCount); extra != defaultRedundancy {
+		t.Errorf("Expected default redundancy %d, got %d", defaultRedundancy, extra)
 	}
 
-	encode, err := EncodeXNCPkt(xnc)
+	est.Update(20, 20)
+	if extra := est.Redundancy(pieceCount); extra != 0 {
+		t.Errorf("Expected no redundancy without loss, got %d", extra)
+	}
+
+	for i := 0; i < 50; i++ {
+		est.Update(20, 18)
+	}
+	if rate := est.Rate(); rate < 0.09 || rate > 0.11 {
+		t.Errorf("Expected loss rate close to 0.1, got %.3f", rate)
+	}
+	if extra := est.Redundancy(pieceCount); extra != 2 {
+		t.Errorf("Expected 2 extra pieces at 10%% loss, got %d", extra)
+	}
+}
+
+func TestRepairDue(t *testing.T) {
+	var track repairTrack
+	for order := 0; order < 4; order++ {
+		track.sentAt(order)
+	}
+	timeout := 10 * time.Millisecond
+	long := time.Now().Add(-time.Second)
+
+	if repair, _ := track.due(false, true, -1, time.Now(), timeout); repair {
+		t.Error("Expected no repair while the client is still reporting")
+	}
+	if repair, probe := track.due(false, false, 3+reorderThreshold, time.Now(), timeout); !repair || probe {
+		t.Error("Expected a full repair once later pieces were reported")
+	}
+	if repair, probe := track.due(true, false, -1, time.Now(), timeout); !repair || probe {
+		t.Error("Expected a full repair once the client caught up")
+	}
+
+	track.lastSent = long
+	if repair, _ := track.due(false, false, -1, long, timeout); repair {
+		t.Error("Expected only the first chunk to be repaired for a silent client")
+	}
+	if repair, probe := track.due(false, true, -1, long, timeout); !repair || probe {
+		t.Error("Expected a full repair of a silent client's first chunk")
+	}
+	if repair, probe := track.due(false, true, -1, long, timeout); !repair || !probe {
+		t.Error("Expected a probe for a client still silent after a repair")
+	}
+
+	if heard := track.report(2, -1); heard != 2 || track.silent != 0 {
+		t.Errorf("Expected the report of piece 2 to be heard, got %d", heard)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	pieceSize := DefaultChunkSize / DefaultPieceCount
+	window := uint(DefaultPieceCount)
+
+	data := make([]byte, 50*pieceSize+123)
+	rand.Read(data)
+
+	enc := NewSlidingWindowEncoderWithPieceSize(data, pieceSize, window)
+	dec := NewSlidingWindowDecoder(enc.PieceCount(), window)
+
+	recv := make([]byte, 0)
+	for sent := 0; !dec.IsDecoded(); sent++ {
+		if sent > 10*int(enc.PieceCount()) {
+			t.Fatalf("Window did not decode, %d pieces delivered", dec.Next())
+		}
+
+		// keep the window full of undelivered pieces, like the server does
+		enc.Advance(dec.Next())
+		enc.Push()
+
+		start, end := enc.Window()
+		_, piece := enc.CodedPiece()
+
+		// simulate 20% loss
+		if rand.Intn(5) == 0 {
+			continue
+		}
+
+		pieces, err := dec.AddPiece(start, end-start, piece)
+		if err != nil {
+			t.Fatalf("Error adding piece: %v", err)
+		}
+
+		for _, p := range pieces {
+			recv = append(recv, p...)
+		}
+	}
+
+	if !bytes.Equal(data, recv[:len(data)]) {
+		t.Errorf("## Window decoded data does not match.")
+	}
+}
+
+func TestEND(t *testing.T) {
+	pkt := EncodeEND(7)
+
+	size, err := FrameSize(pkt)
+	if err != nil || size != len(pkt) {
+		t.Errorf("Expected END frame size %d, got %d: %v", len(pkt), size, err)
+	}
+
+	id, err := DecodeEND(pkt)
+	if err != nil || id != 7 {
+		t.Errorf("Failed to decode END correctly.\nExpected: %v\nGot: %v, %v", 7, id, err)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	info := XNC_INFO{
+		Type:     TYPE_INFO,
+		Status:   STATUS_OK,
+		ChunkNum: 7,
+		FileSize: 100000,
+	}
+
+	pkt, err := EncodeInfo(info)
 	if err != nil {
-		t.Errorf("Error encode xnc: %v", err)
-		return
+		t.Fatalf("Failed to encode info: %v", err)
 	}
-	decode, err := DecodeXNCPkt(encode)
+
+	infoD, err := DecodeInfo(pkt)
+	if err != nil || infoD != info {
+		t.Errorf("Failed to decode info correctly.\nExpected: %+v\nGot: %+v, %v", info, infoD, err)
+	}
+
+	// ranges past 4 GiB are announced with their full size
+	info.FileSize = 5 << 30
+	info.ChunkNum = info.FileSize / DefaultChunkSize
+	pkt, err = EncodeInfo(info)
 	if err != nil {
-		t.Errorf("Error decode xnc: %v", err)
-		return
+		t.Fatalf("Failed to encode info: %v", err)
+	}
+	if infoD, err := DecodeInfo(pkt); err != nil || infoD != info {
+		t.Errorf("Failed to decode a 5 GiB info.\nExpected: %+v\nGot: %+v, %v", info, infoD, err)
 	}
 
-	if !XNCEqual(xnc, decode) {
-		t.Errorf("Failed to decode xnc correctly.\nExpected: %v\nGot: %v", xnc, decode)
-		return
+	if err := statusError(STATUS_NOT_FOUND, "test.m4s"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected not found, got %v", err)
+	}
+	if err := statusError(STATUS_FORBIDDEN, "test.m4s"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected forbidden, got %v", err)
 	}
+}
 
-	t.Logf("## Successfully decoded XNC packets\n")
+func TestChunkSize(t *testing.T) {
+	conf := DefaultConfig()
+	conf.ChunkSize = 4096
+	conf.PieceCount = 4
+	// the last chunk is 100 bytes
+	r := &Reader{conf: conf, size: 2*4096 + 100, chunks: 3, srcs: []*source{{}}}
+
+	piece := &kodr.CodedPiece{Vector: make(kodr.CodingVector, 4), Piece: make([]byte, 1024)}
+	frame := func(id int, size int) []byte {
+		pkt, err := GetXNCEncPkt(size, id, 3, 0, piece)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pkt
+	}
+
+	for _, c := range []struct {
+		id   int
+		size int
+		ok   bool
+	}{
+		{0, 4096, true},
+		{2, 100, true},
+		{1, 100, false},
+		{2, 4096, false},
+		{2, 0, false},
+		{3, 4096, false},
+	} {
+		r.frames = make(chan sourceFrame, 1)
+		r.frames <- sourceFrame{pkt: frame(c.id, c.size)}
+
+		_, err := r.readPkt()
+		if c.ok && err != nil {
+			t.Errorf("Expected chunk %v of %v bytes to be accepted, got %v", c.id, c.size, err)
+		}
+		if !c.ok && !errors.Is(err, ErrDecode) {
+			t.Errorf("Expected chunk %v of %v bytes to be rejected, got %v", c.id, c.size, err)
+		}
+	}
 }
 
-func XNCEqual(a, b XNC) bool {
-	if a.ChunkId != b.ChunkId {
-		return false
+func TestResolvePath(t *testing.T) {
+	allowed := map[string]string{
+		"test.m4s":       "/srv/test.m4s",
+		"/test.m4s":      "/srv/test.m4s",
+		"video/test.m4s": "/srv/video/test.m4s",
+		"./a//b.m4s":     "/srv/a/b.m4s",
 	}
-	if a.Type != b.Type {
-		return false
+	for name, expected := range allowed {
+		if p, ok := resolvePath("/srv", name); !ok || p != expected {
+			t.Errorf("Expected %v for %v, got %v, %v", expected, name, p, ok)
+		}
 	}
-	if a.ChunkSize != b.ChunkSize {
-		return false
+
+	for _, name := range []string{"..", "../etc/passwd", "/../etc/passwd", "video/../../etc/passwd"} {
+		if p, ok := resolvePath("/srv", name); ok {
+			t.Errorf("Expected %v to be refused, got %v", name, p)
+		}
 	}
+}
 
-	if a.ChunkNum != b.ChunkNum {
+// serving is a Server or Relay run by a test
+type serving interface {
+	Serve(ctx context.Context, listener quic.Listener) error
+}
+
+// serve runs srv on a free port of localhost until the test ends and
+// returns its address
+func serve(t *testing.T, srv serving) string {
+	t.Helper()
+
+	listener, err := quic.ListenAddr("localhost:0", GenerateTLSConfig(), &quic.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(ctx, listener)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		listener.Close()
+		<-done
+	})
+
+	return listener.Addr().String()
+}
+
+// newTestServer serves a new Server with conf until the test ends
+func newTestServer(t *testing.T, conf *Config) (*Server, string) {
+	t.Helper()
+
+	server, err := NewServer(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, serve(t, server)
+}
+
+// scriptedServer answers every request by calling itself instead of
+// coding the file, for tests which control what a client is sent
+type scriptedServer func(stream quic.Stream, conn *pktConn, init XNC_INIT)
+
+func (h scriptedServer) Serve(ctx context.Context, listener quic.Listener) error {
+	for {
+		sess, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			for {
+				stream, err := sess.AcceptStream()
+				if err != nil {
+					return
+				}
+
+				go func() {
+					defer stream.Close()
+
+					init, conf, ok := readRequest(stream, (&Config{}).withDefaults())
+					if ok {
+						h(stream, newPktConn(sess, stream, conf.Datagram), init)
+					}
+				}()
+			}
+		}()
+	}
+}
+
+// writeChunk sends the first count uncoded pieces of chunk id of data the
+// way init asked for it
+func writeChunk(conn *pktConn, data []byte, init XNC_INIT, id int, count int) error {
+	chunkSize := int(init.ChunkSize)
+	chunkNum := (len(data) + chunkSize - 1) / chunkSize
+
+	chunk := make([]byte, chunkSize)
+	size := copy(chunk, data[id*chunkSize:])
+	pieces, _, err := kodr.OriginalPiecesFromDataAndPieceCount(chunk, uint(init.PieceCount))
+	if err != nil {
+		return err
+	}
+
+	enc := newRandEncoder(pieces, true, nil)
+	for seq := 0; seq < count; seq++ {
+		pkt, err := GetXNCEncPkt(size, id, chunkNum, seq, enc.CodedPiece())
+		if err != nil {
+			return err
+		}
+		if err := conn.WritePkt(pkt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func TestFileSource(t *testing.T) {
+	data := make([]byte, 100000)
+	rand.Read(data)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(dir+"/test.m4s", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mem := NewMemSource()
+	mem.Put("video/test.m4s", data)
+
+	var fetches int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		http.ServeFile(w, req, dir+req.URL.Path)
+	}))
+	defer origin.Close()
+	cached := NewHTTPSource(origin.URL, t.TempDir())
+
+	sources := map[string]FileSource{
+		"test.m4s":        DirSource(dir),
+		"/video/test.m4s": mem,
+		"./test.m4s":      cached,
+	}
+	for name, files := range sources {
+		for i := 0; i < 2; i++ {
+			file, err := files.Open(name)
+			if err != nil {
+				t.Fatalf("Error opening %v from %T: %v", name, files, err)
+			}
+			info, err := file.Stat()
+			if err != nil || info.Size() != int64(len(data)) {
+				t.Errorf("Expected %v bytes in %v from %T, got %v, %v", len(data), name, files, info, err)
+			}
+			buf := make([]byte, 1000)
+			if _, err := file.ReadAt(buf, 5000); err != nil || !bytes.Equal(buf, data[5000:6000]) {
+				t.Errorf("Read wrong data from %v of %T: %v", name, files, err)
+			}
+			file.Close()
+		}
+
+		if _, err := files.Open("missing.m4s"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Expected %T to miss missing.m4s, got %v", files, err)
+		}
+		if _, err := files.Open("../test.m4s"); !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("Expected %T to refuse ../test.m4s, got %v", files, err)
+		}
+	}
+	// one fetch of test.m4s and one of missing.m4s, the second open came
+	// from the cache
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("Expected 2 fetches from the origin, got %v", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr := newTestServer(t, &Config{Files: mem})
+
+	client, err := NewClient(&Config{Addr: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	recv, _, _, err := client.Get(ctx, "video/test.m4s", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, recv) {
+		t.Error("File served from memory does not match")
+	}
+}
+
+func TestRange(t *testing.T) {
+	data := make([]byte, 3*4096+500)
+	rand.Read(data)
+	mem := NewMemSource()
+	mem.Put("range.m4s", data)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr := newTestServer(t, &Config{Files: mem})
+
+	client, err := NewClient(&Config{Addr: addr, ChunkSize: 4096, PieceCount: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ranges := []struct {
+		offset, length int64
+		want           []byte
+	}{
+		// not aligned to chunks or pieces, across a chunk boundary
+		{1000, 5000, data[1000:6000]},
+		// running past the end of the file, cut short
+		{10000, 1 << 20, data[10000:]},
+		// without a length, up to the end of the file
+		{4097, 0, data[4097:]},
+	}
+	for _, encode := range []bool{true, false} {
+		for _, rg := range ranges {
+			recv, _, _, err := client.GetRange(ctx, "range.m4s", rg.offset, rg.length, encode)
+			if err != nil {
+				t.Fatalf("Range %v+%v, coded %v: %v", rg.offset, rg.length, encode, err)
+			}
+			if !bytes.Equal(rg.want, recv) {
+				t.Errorf("Range %v+%v, coded %v: got %v bytes that don't match", rg.offset, rg.length, encode, len(recv))
+			}
+		}
+	}
+
+	// a range starting past the end of the file is refused
+	conn, err := client.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, pc, err := conn.request(XNC_INIT{Type: TYPE_INIT_ENC, ChunkSize: 4096, PieceCount: 16, Offset: int64(len(data) + 1), Len: len("range.m4s"), Filename: "range.m4s"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	if info, err := readInfoFrom(stream); err != nil || info.Status != STATUS_BAD_REQUEST {
+		t.Errorf("Expected STATUS_BAD_REQUEST for a range past the end, got %+v, %v", info, err)
+	}
+
+	if _, _, _, err := client.GetRange(ctx, "range.m4s", int64(len(data)+1), 0, true); !errors.Is(err, ErrServer) {
+		t.Errorf("Expected ErrServer for a range past the end, got %v", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	data := make([]byte, 64*4096+300)
+	rand.Read(data)
+	mem := NewMemSource()
+	mem.Put("open.m4s", data)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr := newTestServer(t, &Config{Files: mem})
+
+	conn, err := Dial(ctx, &Config{Addr: addr, ChunkSize: 4096, PieceCount: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r, err := conn.Open(ctx, "open.m4s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// the head of the file is read while the rest is still coming
+	buf := make([]byte, 1000)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[:1000], buf) {
+		t.Fatal("Head of the file does not match")
+	}
+	if stats := r.Stats(); stats.Bytes >= len(data) {
+		t.Errorf("Expected only part of the file decoded after the first read, got %v of %v bytes", stats.Bytes, len(data))
+	}
+
+	recv := append([]byte(nil), buf...)
+	for {
+		n, err := r.Read(buf)
+		recv = append(recv, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(data, recv) {
+		t.Error("File read piece by piece does not match")
+	}
+	if n, err := r.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Expected io.EOF after the end of the file, got %v, %v", n, err)
+	}
+}
+
+func TestConcurrentGets(t *testing.T) {
+	files := map[string][]byte{
+		"video.m4s": make([]byte, 40*4096),
+		"audio.m4s": make([]byte, 10*4096+77),
+	}
+	mem := NewMemSource()
+	for name, data := range files {
+		rand.Read(data)
+		mem.Put(name, data)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, addr := newTestServer(t, &Config{Files: mem})
+
+	client, err := NewClient(&Config{Addr: addr, ChunkSize: 4096, PieceCount: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn, err := client.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, len(files))
+	for name, data := range files {
+		go func(name string, data []byte) {
+			recv, _, _, err := client.Get(ctx, name, true)
+			if err == nil && !bytes.Equal(data, recv) {
+				err = fmt.Errorf("%v fetched alongside another file does not match", name)
+			}
+			errs <- err
+		}(name, data)
+	}
+	for range files {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	// both requests went over the one session
+	if again, err := client.Conn(ctx); err != nil || again != conn {
+		t.Errorf("Expected the requests to share the session, got %v", err)
+	}
+	if served := server.Stats(); served.Requests != 2 {
+		t.Errorf("Expected 2 requests, got %+v", served)
+	}
+	if transfers := client.Transfers(); len(transfers) != 2 {
+		t.Errorf("Expected 2 transfers, got %v", len(transfers))
+	}
+}
+
+func TestDigest(t *testing.T) {
+	chunks := [][]byte{[]byte("first chunk"), []byte("second chunk")}
+
+	digests := newDigestSet(len(chunks))
+	for id, chunk := range chunks {
+		pkt, err := EncodeDigest(id, chunkDigest(chunk))
+		if err != nil {
+			t.Fatalf("Failed to encode digest: %v", err)
+		}
+
+		size, err := FrameSize(pkt)
+		if err != nil || size != len(pkt) {
+			t.Errorf("Expected DIGEST frame size %d, got %d: %v", len(pkt), size, err)
+		}
+
+		idD, digest, err := DecodeDigest(pkt)
+		if err != nil || idD != id {
+			t.Fatalf("Failed to decode digest correctly.\nExpected: %v\nGot: %v, %v", id, idD, err)
+		}
+		if err := digests.Add(idD, digest); err != nil {
+			t.Fatalf("Failed to add digest: %v", err)
+		}
+	}
+	if err := digests.Add(len(chunks), chunkDigest(bytes.Join(chunks, nil))); err != nil {
+		t.Fatalf("Failed to add file digest: %v", err)
+	}
+
+	if err := digests.CheckChunk(0, chunks[0]); err != nil {
+		t.Errorf("Chunk 0 failed verification: %v", err)
+	}
+	if err := digests.CheckChunk(1, chunks[0]); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("Expected chunk 1 to fail verification, got %v", err)
+	}
+
+	digests.Write(chunks[0])
+	digests.Write(chunks[1])
+	if err := digests.CheckFile(); err != nil {
+		t.Errorf("File failed verification: %v", err)
+	}
+	digests.Write([]byte{0})
+	if err := digests.CheckFile(); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("Expected file to fail verification, got %v", err)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	conf := (&Config{PieceCount: 32}).withDefaults()
+	if conf.Addr != DefaultAddr || conf.ChunkSize != DefaultChunkSize || conf.PieceCount != 32 || conf.Generations != DefaultGenerations {
+		t.Errorf("Unexpected config defaults: %+v", conf)
+	}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("Expected valid config: %v", err)
+	}
+
+	// seeded frames carry no vector, 1024 pieces of 1024 bytes still fit
+	seeded := &Config{ChunkSize: 1 << 20, PieceCount: MAXSEEDPIECECOUNT, Datagram: true, Seed: true}
+	if err := seeded.Validate(); err != nil {
+		t.Errorf("Expected valid seeded config: %v", err)
+	}
+
+	invalid := []*Config{
+		{ChunkSize: 1000, PieceCount: 16},
+		{ChunkSize: 1 << 14, PieceCount: MAXPIECECOUNT + 1},
+		{ChunkSize: MAXCHUNKSIZE * 2, PieceCount: 16},
+		// 4096 byte pieces don't fit in a datagram
+		{ChunkSize: 1 << 14, PieceCount: 4, Datagram: true},
+		{ChunkSize: 1 << 20, PieceCount: MAXSEEDPIECECOUNT},
+		{ChunkSize: 1 << 20, PieceCount: MAXSEEDPIECECOUNT * 2, Seed: true},
+		{ChunkSize: 1 << 14, PieceCount: 16, Generations: -1},
+		{ChunkSize: 1 << 14, PieceCount: 16, Generations: 1 << 16},
+		{ChunkSize: 1 << 14, PieceCount: 16, Interleave: maxInFlight + 1},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected invalid config: %+v", c)
+		}
+	}
+}
+
+func TestGenerationReader(t *testing.T) {
+	conf := &Config{ChunkSize: 4096, PieceCount: 8, Digest: true}
+
+	data := make([]byte, 5*conf.ChunkSize+123)
+	rand.Read(data)
+
+	for _, initType := range []byte{TYPE_INIT, TYPE_INIT_ENC, TYPE_INIT_SYS} {
+		gens := newGenerationReader(bytes.NewReader(data), int64(len(data)), conf, initType, 0, nil, fileKey{}, nil)
+		if gens.Count() != 6 {
+			t.Fatalf("Expected 6 chunks, got %d", gens.Count())
+		}
+
+		recv := make([]byte, 0)
+		for {
+			gen, err := gens.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Error reading generation: %v", err)
+			}
+
+			chunk := gen.data
+			if initType != TYPE_INIT {
+				var decoder ChunkDecoder = full.NewFullRLNCDecoder(uint(conf.PieceCount))
+				if initType == TYPE_INIT_SYS {
+					decoder = NewSystematicDecoder(uint(conf.PieceCount))
+				}
+				for !decoder.IsDecoded() {
+					if err := decoder.AddPiece(gen.enc.CodedPiece()); err != nil {
+						t.Fatalf("Error adding piece: %v", err)
+					}
+				}
+
+				chunk, err = GetFile(decoder)
+				if err != nil {
+					t.Fatalf("Error getting chunk: %v", err)
+				}
+			}
+
+			recv = append(recv, chunk[:gen.size]...)
+		}
+
+		// a chunk read again carries no digest and doesn't touch the
+		// digest of the file
+		gen, err := gens.Reopen(5)
+		gens.Close()
+		if err != nil || gen.size != 123 || gen.digest != nil {
+			t.Errorf("Error reopening chunk: %v", err)
+		}
+
+		if !bytes.Equal(data, recv) {
+			t.Errorf("## Generations do not match the file, init type %v", initType)
+		}
+	}
+}
+
+func TestGenerationWindow(t *testing.T) {
+	data := make([]byte, 4*4096)
+	rand.Read(data)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the client opens a decoder with the first piece of a chunk, drops
+	// pieces past its window and frees a decoder once its chunk decodes
+	half, more := make(chan struct{}), make(chan struct{})
+	addr := serve(t, scriptedServer(func(stream quic.Stream, conn *pktConn, init XNC_INIT) {
+		sendInfo(stream, XNC_INFO{Type: TYPE_INFO, Status: STATUS_OK, ChunkNum: 4, FileSize: len(data)})
+
+		feedback := make(chan XNC_ACK)
+		done := make(chan struct{})
+		defer close(done)
+		go readACKs(stream, feedback, done)
+
+		writeChunk(conn, data, init, 2, 16)
+		writeChunk(conn, data, init, 0, 8)
+		writeChunk(conn, data, init, 1, 8)
+		for ack := range feedback {
+			if ack.Type == TYPE_ACK_RANK && ack.ChunkId == 1 && ack.Required == 8 {
+				break
+			}
+		}
+		close(half)
+		<-more
+
+		for id := 0; id < 4; id++ {
+			writeChunk(conn, data, init, id, 16)
+		}
+		for range feedback {
+		}
+	}))
+
+	conn, err := Dial(ctx, &Config{Addr: addr, ChunkSize: 4096, PieceCount: 16, Generations: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r, err := conn.Open(ctx, "window.m4s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	<-half
+	if stats := r.Stats(); stats.Decoders != 2 || stats.Pieces != 32 || stats.Innovative != 16 {
+		t.Errorf("Expected 2 decoders open and the pieces of chunk 2 dropped, got %+v", stats)
+	}
+	close(more)
+
+	recv, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, recv) {
+		t.Error("File decoded in a window of 2 generations does not match")
+	}
+	if stats := r.Stats(); stats.Decoders != 0 || stats.MaxDecoders != 2 || stats.Innovative != 64 {
+		t.Errorf("Expected every decoder freed and at most 2 open, got %+v", stats)
+	}
+
+	// the server opens no chunk past the window until the first one is
+	// decoded
+	mem := NewMemSource()
+	mem.Put("window.m4s", data)
+	_, addr = newTestServer(t, &Config{Files: mem})
+
+	conn, err = Dial(ctx, &Config{Addr: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stream, pc, err := conn.request(XNC_INIT{Type: TYPE_INIT_ENC, ChunkSize: 4096, PieceCount: 16, Window: 2, Len: len("window.m4s"), Filename: "window.m4s"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	if info, err := readInfoFrom(stream); err != nil || info.ChunkNum != 4 {
+		t.Fatalf("Unexpected answer %+v, %v", info, err)
+	}
+
+	chunks := make(chan int)
+	go func() {
+		defer close(chunks)
+		for {
+			pkt, err := pc.ReadPkt()
+			if err != nil {
+				return
+			}
+			xncD, err := DecodeXNCPkt(pkt)
+			if err != nil {
+				return
+			}
+			chunks <- xncD.ChunkId
+		}
+	}()
+
+	// no feedback, the server repairs the chunks it opened
+	timeout := time.After(300 * time.Millisecond)
+	for waiting := true; waiting; {
+		select {
+		case id := <-chunks:
+			if id > 1 {
+				t.Fatalf("Server sent chunk %v past a window of 2", id)
+			}
+		case <-timeout:
+			waiting = false
+		}
+	}
+
+	ack, err := EncodeACK(XNC_ACK{Type: TYPE_ACK_DECODED, ChunkId: 0, Received: 16, Seq: 15})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Write(ack); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout = time.After(2 * time.Second)
+	for opened := false; !opened; {
+		select {
+		case id := <-chunks:
+			if id == 3 {
+				t.Fatal("Server sent chunk 3 past a window of 2 from chunk 1")
+			}
+			opened = id == 2
+		case <-timeout:
+			t.Fatal("Server didn't open chunk 2 once chunk 0 was decoded")
+		}
+	}
+}
+
+func TestChunkCache(t *testing.T) {
+	conf := &Config{ChunkSize: 4096, PieceCount: 8}
+
+	data := make([]byte, 2*conf.ChunkSize+123)
+	rand.Read(data)
+
+	// room for four chunks with their pools, the file has three
+	pool := 10
+	cache := newChunkCache(4*int64(conf.ChunkSize+pool*(conf.PieceCount+conf.ChunkSize/conf.PieceCount)), pool)
+	file := fileKey{name: "/test.m4s", size: int64(len(data)), length: int64(len(data))}
+	other := file
+	other.name = "/other.m4s"
+
+	for run, key := range []fileKey{file, file, other} {
+		gens := newGenerationReader(bytes.NewReader(data), int64(len(data)), conf, TYPE_INIT_ENC, 0, cache, key, nil)
+
+		recv := make([]byte, 0)
+		for {
+			gen, err := gens.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Error reading generation: %v", err)
+			}
+
+			// the decoder works in place, pooled pieces are shared
+			decoder := full.NewFullRLNCDecoder(uint(conf.PieceCount))
+			for !decoder.IsDecoded() {
+				piece := gen.enc.CodedPiece()
+				copied := &kodr.CodedPiece{
+					Vector: append(kodr.CodingVector{}, piece.Vector...),
+					Piece:  append(kodr.Piece{}, piece.Piece...),
+				}
+				if err := decoder.AddPiece(copied); err != nil {
+					t.Fatalf("Error adding piece: %v", err)
+				}
+			}
+			chunk, err := GetFile(decoder)
+			if err != nil {
+				t.Fatalf("Error getting chunk: %v", err)
+			}
+			recv = append(recv, chunk[:gen.size]...)
+		}
+		gens.Close()
+
+		if !bytes.Equal(data, recv) {
+			t.Errorf("Cached generations do not match the file, run %v", run)
+		}
+	}
+
+	// the second run only used cached chunks, the other file pushed out
+	// two of them
+	stats := cache.Stats()
+	if stats.Hits != 3 || stats.Misses != 6 || stats.Evictions != 2 || stats.Chunks != 4 {
+		t.Errorf("Unexpected cache stats %+v", stats)
+	}
+	if stats.Bytes > cache.max || stats.PoolPieces < int64(9*conf.PieceCount) {
+		t.Errorf("Unexpected cache stats %+v", stats)
+	}
+
+	// the file changed, none of its chunks can be used
+	file.modTime++
+	if _, ok := cache.get(chunkKey{file: file, chunkSize: conf.ChunkSize, pieceCount: conf.PieceCount, id: 2}); ok {
+		t.Error("Expected a changed file to miss the cache")
+	}
+
+	mem := NewMemSource()
+	mem.Put("cached.m4s", data)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, addr := newTestServer(t, &Config{Files: mem, CacheBytes: 1 << 20, CachePool: 20})
+
+	client, err := NewClient(&Config{Addr: addr, ChunkSize: conf.ChunkSize, PieceCount: conf.PieceCount})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for run := 0; run < 2; run++ {
+		recv, _, _, err := client.Get(ctx, "cached.m4s", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, recv) {
+			t.Errorf("File served from the cache does not match, run %v", run)
+		}
+	}
+	if stats := server.CacheStats(); stats.Hits != 3 || stats.Misses != 3 || stats.PoolPieces == 0 {
+		t.Errorf("Unexpected server cache stats %+v", stats)
+	}
+
+	replaced := make([]byte, len(data))
+	rand.Read(replaced)
+	mem.Put("cached.m4s", replaced)
+
+	recv, _, _, err := client.Get(ctx, "cached.m4s", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(replaced, recv) {
+		t.Error("Expected the replaced file, not the cached one")
+	}
+}
+
+func TestCodingSources(t *testing.T) {
+	conf := &Config{ChunkSize: 4096, PieceCount: 8}
+
+	data := make([]byte, conf.ChunkSize)
+	rand.Read(data)
+
+	pool := 10
+	cache := newChunkCache(1<<20, pool)
+	file := fileKey{name: "/sources.m4s", size: int64(len(data)), length: int64(len(data))}
+
+	first := func(source int) generation {
+		gens := newGenerationReader(bytes.NewReader(data), int64(len(data)), conf, TYPE_INIT_ENC, source, cache, file, nil)
+		defer gens.Close()
+
+		gen, err := gens.Next()
+		if err != nil {
+			t.Fatalf("Error reading generation: %v", err)
+		}
+		return gen
+	}
+
+	// only the first server takes pieces from the shared pool
+	primary, mirror := first(0), first(1)
+	if _, ok := primary.enc.(*pooledEncoder); !ok {
+		t.Errorf("Expected the first server to use the pool, got %T", primary.enc)
+	}
+	if _, ok := mirror.enc.(*pooledEncoder); ok {
+		t.Error("Expected a mirror to code its own pieces")
+	}
+
+	// the pieces of both servers add up in one decoder, a mirror repeating
+	// pooled pieces would only send dependent ones
+	decoder := full.NewFullRLNCDecoder(uint(conf.PieceCount))
+	dependent := 0
+	for i := 0; i < conf.PieceCount/2; i++ {
+		for _, enc := range []pieceEncoder{primary.enc, mirror.enc} {
+			piece := enc.CodedPiece()
+			copied := &kodr.CodedPiece{
+				Vector: append(kodr.CodingVector{}, piece.Vector...),
+				Piece:  append(kodr.Piece{}, piece.Piece...),
+			}
+			required := decoder.Required()
+			if err := decoder.AddPiece(copied); err != nil && !errors.Is(err, kodr.ErrAllUsefulPiecesReceived) {
+				t.Fatalf("Error adding piece: %v", err)
+			}
+			if decoder.Required() == required {
+				dependent++
+			}
+		}
+	}
+	if dependent > 1 {
+		t.Errorf("Expected the pieces of both servers to be innovative, %v of %v were dependent", dependent, conf.PieceCount)
+	}
+}
+
+func TestSystematic(t *testing.T) {
+	pieceCount := uint(DefaultPieceCount)
+
+	data := make([]byte, DefaultChunkSize)
+	rand.Read(data)
+
+	// without loss the uncoded pieces are the chunk, no decoding needed
+	enc, _ := systematic.NewSystematicRLNCEncoderWithPieceCount(data, pieceCount)
+	dec := NewSystematicDecoder(pieceCount)
+	recv := make([]byte, 0)
+	for !dec.IsDecoded() {
+		if err := dec.AddPiece(enc.CodedPiece()); err != nil {
+			t.Fatalf("Error adding piece: %v", err)
+		}
+		for _, piece := range dec.Ready() {
+			recv = append(recv, piece...)
+		}
+	}
+	if dec.decoder != nil || dec.GetRecv() != pieceCount {
+		t.Errorf("Lossless chunk was decoded from %d pieces", dec.GetRecv())
+	}
+	if !bytes.Equal(data, recv) {
+		t.Errorf("## Uncoded pieces do not match the chunk.")
+	}
+
+	// lost uncoded pieces are made up by repair pieces
+	enc, _ = systematic.NewSystematicRLNCEncoderWithPieceCount(data, pieceCount)
+	dec = NewSystematicDecoder(pieceCount)
+	ready := 0
+	for sent := 0; !dec.IsDecoded(); sent++ {
+		if sent > 10*int(pieceCount) {
+			t.Fatalf("Chunk did not decode, %d pieces required", dec.Required())
+		}
+
+		piece := enc.CodedPiece()
+		if sent == 0 || sent == 5 || rand.Intn(5) == 0 {
+			continue
+		}
+		if err := dec.AddPiece(piece); err != nil {
+			t.Fatalf("Error adding piece: %v", err)
+		}
+		ready += len(dec.Ready())
+	}
+	if ready != 0 {
+		t.Errorf("%d pieces ready after losing the first one", ready)
+	}
+
+	chunk, err := GetFile(dec)
+	if err != nil {
+		t.Fatalf("Error getting chunk: %v", err)
+	}
+	if !bytes.Equal(data, chunk) {
+		t.Errorf("## Repaired chunk does not match.")
+	}
+}
+
+func TestSeed(t *testing.T) {
+	// first SplitMix64 output for state 0, least significant byte first
+	expected := []byte{0xaf, 0xcd, 0x1d, 0x7b, 0x39, 0xa8, 0x20, 0xe2, 0x94}
+	if vector := SeedVector(0, 9); !bytes.Equal(vector[:8], expected[:8]) {
+		t.Fatalf("Seed 0 expands to %x, expected %x", vector, expected[:8])
+	}
+	if !bytes.Equal(SeedVector(42, 300), SeedVector(42, 300)) || bytes.Equal(SeedVector(42, 16), SeedVector(43, 16)) {
+		t.Errorf("Seed expansion is not deterministic")
+	}
+
+	pieceCount := uint(512)
+	data := make([]byte, 512*16)
+	rand.Read(data)
+
+	for _, sys := range []bool{false, true} {
+		enc, err := NewSeededEncoderWithPieceCount(data, pieceCount, 3, sys)
+		if err != nil {
+			t.Fatalf("Error creating encoder: %v", err)
+		}
+
+		var decoder ChunkDecoder = full.NewFullRLNCDecoder(pieceCount)
+		if sys {
+			decoder = NewSystematicDecoder(pieceCount)
+		}
+
+		for sent := 0; !decoder.IsDecoded(); sent++ {
+			if sent > 2*int(pieceCount) {
+				t.Fatalf("Chunk did not decode, %d pieces required", decoder.Required())
+			}
+
+			seed, piece, uncoded := enc.SeededPiece()
+			if uncoded != (sys && sent < int(pieceCount)) {
+				t.Fatalf("Piece %d uncoded %v, systematic %v", sent, uncoded, sys)
+			}
+			if rand.Intn(10) == 0 {
+				continue
+			}
+
+			vector := SeedVector(seed, int(pieceCount))
+			if uncoded {
+				vector = make(kodr.CodingVector, pieceCount)
+				vector[seed] = 1
+			}
+			if err := decoder.AddPiece(&kodr.CodedPiece{Vector: vector, Piece: piece}); err != nil {
+				t.Fatalf("Error adding piece: %v", err)
+			}
+		}
+
+		chunk, err := GetFile(decoder)
+		if err != nil {
+			t.Fatalf("Error getting chunk: %v", err)
+		}
+		if !bytes.Equal(data, chunk) {
+			t.Errorf("## Seeded chunk does not match, systematic %v", sys)
+		}
+	}
+
+	// an encoder skipping pieces carries on with the same seeds
+	for _, sys := range []bool{false, true} {
+		a, _ := NewSeededEncoderWithPieceCount(data, 16, 7, sys)
+		b, _ := NewSeededEncoderWithPieceCount(data, 16, 7, sys)
+		for i := 0; i < 20; i++ {
+			a.SeededPiece()
+		}
+		b.skip(20)
+
+		seedA, _, _ := a.SeededPiece()
+		seedB, _, _ := b.SeededPiece()
+		if seedA != seedB {
+			t.Errorf("Skipped encoder sends seed %x, expected %x, systematic %v", seedB, seedA, sys)
+		}
+	}
+
+	xnc := XNC{Type: TYPE_XNC_SEED, ChunkId: 2, ChunkSize: 100, ChunkNum: 5, Seq: 9, PieceCount: 1000, Seed: 0xdeadbeef01, Piece: make([]byte, 100)}
+	pkt, err := EncodeXNCPkt(xnc)
+	if err != nil || len(pkt) != HEADERSIZE+SEEDSIZE+100 {
+		t.Fatalf("Error encoding seeded frame: %v", err)
+	}
+	decode, err := DecodeXNCPkt(pkt)
+	if err != nil || !XNCEqual(xnc, decode) || decode.Seed != xnc.Seed {
+		t.Errorf("Failed to decode seeded frame: %v", err)
+	}
+}
+
+func TestInterleave(t *testing.T) {
+	var order []int
+	err := interleave([]burst{{0, 3}, {1, 1}, {2, 2}}, func(chunk int) error {
+		order = append(order, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []int{0, 1, 2, 0, 2, 0}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected %v, got %v", expected, order)
+	}
+}
+
+// pathSession is a multipath session as far as its path stats go
+type pathSession struct {
+	quic.Session
+	stats []quic.PathStats
+}
+
+func (s *pathSession) PathStats() []quic.PathStats {
+	return s.stats
+}
+
+func TestPathSteer(t *testing.T) {
+	sess := &pathSession{stats: []quic.PathStats{
+		{PathID: 0, SmoothedRTT: time.Millisecond},
+		{PathID: 1, SmoothedRTT: 30 * time.Millisecond, PacketsSent: 100, PacketsLost: 1},
+		{PathID: 3, SmoothedRTT: 80 * time.Millisecond, PacketsSent: 100, PacketsLost: 20},
+		{PathID: 5, SmoothedRTT: 10 * time.Millisecond, PacketsSent: 100, PacketsLost: 5},
+	}}
+	steer := newPathSteer(sess)
+
+	if id, ok := steer.path(false); !ok || id != 5 {
+		t.Errorf("Expected needed pieces on path 5, got %v, %v", id, ok)
+	}
+	if id, ok := steer.path(true); !ok || id != 3 {
+		t.Errorf("Expected redundancy on path 3, got %v, %v", id, ok)
+	}
+
+	// the fast path fails, and with it the last choice for redundancy
+	sess.stats[3].PotentiallyFailed = true
+	sess.stats[2].PotentiallyFailed = true
+	steer.update(sess.stats)
+	if _, ok := steer.path(false); ok {
+		t.Error("Expected no steering with one usable path")
+	}
+
+	sess.stats = sess.stats[:1]
+	steer.update(sess.stats)
+	if _, ok := steer.path(true); ok {
+		t.Error("Expected no steering on a single path session")
+	}
+}
+
+func TestMissingChunk(t *testing.T) {
+	data := make([]byte, 4*4096+1000)
+	rand.Read(data)
+	const chunkNum, lost = 5, 2
+
+	asked := make(chan XNC_ACK, chunkNum)
+	addr := serve(t, scriptedServer(func(stream quic.Stream, conn *pktConn, init XNC_INIT) {
+		sendInfo(stream, XNC_INFO{Type: TYPE_INFO, Status: STATUS_OK, ChunkNum: chunkNum, FileSize: len(data)})
+
+		// the chunks arrive last to first and one of them never does, as
+		// if the server had heard it was decoded
+		for id := chunkNum - 1; id >= 0; id-- {
+			if id == lost {
+				continue
+			}
+			if err := writeChunk(conn, data, init, id, int(init.PieceCount)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		sendEnd(conn, chunkNum-1)
+
+		feedback := make(chan XNC_ACK)
+		done := make(chan struct{})
+		defer close(done)
+		go readACKs(stream, feedback, done)
+
+		for ack := range feedback {
+			if ack.Type != TYPE_ACK_MORE {
+				continue
+			}
+			asked <- ack
+			if err := writeChunk(conn, data, init, ack.ChunkId, ack.Required); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, err := NewClient(&Config{Addr: addr, ChunkSize: 4096, PieceCount: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	recv, _, _, err := client.Get(ctx, "missing.m4s", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, recv) {
+		t.Fatal("Chunks put back together out of order do not match the file")
+	}
+
+	select {
+	case ack := <-asked:
+		if ack.ChunkId != lost || ack.Required != 16 {
+			t.Errorf("Expected all 16 pieces of chunk %v asked for again, got %+v", lost, ack)
+		}
+	default:
+		t.Error("Expected the lost chunk to be asked for again after END")
+	}
+	if len(asked) > 0 {
+		t.Errorf("Expected only chunk %v asked for, got %+v too", lost, <-asked)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	data := make([]byte, 2*4096)
+	rand.Read(data)
+	mem := NewMemSource()
+	mem.Put("reopen.m4s", data)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr := newTestServer(t, &Config{Files: mem})
+
+	conn, err := Dial(ctx, &Config{Addr: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stream, pc, err := conn.request(XNC_INIT{Type: TYPE_INIT_ENC, ChunkSize: 4096, PieceCount: 16, Len: len("reopen.m4s"), Filename: "reopen.m4s"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	if _, err := readInfoFrom(stream); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := make(chan XNC)
+	go func() {
+		defer close(frames)
+		for {
+			pkt, err := pc.ReadPkt()
+			if err != nil {
+				return
+			}
+			xncD, err := DecodeXNCPkt(pkt)
+			if err != nil {
+				return
+			}
+			frames <- xncD
+		}
+	}()
+	ack := func(ack XNC_ACK) {
+		pkt, err := EncodeACK(ack)
+		if err == nil {
+			_, err = stream.Write(pkt)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// both chunks are reported decoded once their first burst is in
+	received := make([]int, 2)
+	for received[0] < 16 || received[1] < 16 {
+		xncD, ok := <-frames
+		if !ok {
+			t.Fatal("Server stopped before sending both chunks")
+		}
+		received[xncD.ChunkId]++
+	}
+	for id := 0; id < 2; id++ {
+		ack(XNC_ACK{Type: TYPE_ACK_DECODED, ChunkId: id, Received: 16, Seq: 15})
+	}
+	for xncD := range frames {
+		if xncD.Type == TYPE_END {
+			break
+		}
+	}
+
+	// chunk 1 is asked for again, the server repairs it from the 2 pieces
+	// the client said it needs, not from a whole chunk
+	ack(XNC_ACK{Type: TYPE_ACK_MORE, ChunkId: 1, Required: 2, Seq: 16})
+
+	pieces := 0
+	timeout := time.After(500 * time.Millisecond)
+	for waiting := true; waiting; {
+		select {
+		case xncD := <-frames:
+			if xncD.Type != TYPE_END && xncD.ChunkId == 1 {
+				pieces++
+			}
+		case <-timeout:
+			waiting = false
+		}
+	}
+	if pieces < 2 || pieces >= 16 {
+		t.Errorf("Expected the reopened chunk repaired from 2 required pieces, got %v pieces", pieces)
+	}
+}
+
+func TestRelay(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 200000)
+	rand.Read(data)
+	if err := os.WriteFile(dir+"/relay.m4s", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, serverAddr := newTestServer(t, &Config{RootDir: dir})
+	relay, err := NewRelay(&Config{}, &Config{Addr: serverAddr, Datagram: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, relay)
+
+	// both clients are served from one fetch of the file, neither one can
+	// finish before both requests are made as nothing is read until then
+	readers := make(map[bool]*Reader)
+	for _, datagram := range []bool{false, true} {
+		conn, err := Dial(ctx, &Config{Addr: addr, Datagram: datagram, Digest: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		r, err := conn.Open(ctx, "relay.m4s")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		readers[datagram] = r
+	}
+
+	errs := make(chan error, len(readers))
+	for datagram, r := range readers {
+		go func(datagram bool, r *Reader) {
+			recv, err := io.ReadAll(r)
+			if err == nil && !bytes.Equal(data, recv) {
+				err = fmt.Errorf("relayed file does not match, datagram %v", datagram)
+			}
+			errs <- err
+		}(datagram, r)
+	}
+	for range readers {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if served := server.Stats(); served.Requests != 1 {
+		t.Errorf("Expected the relay to fetch the file once, got %v requests", served.Requests)
+	}
+}
+
+func TestMirrors(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 1000000)
+	rand.Read(data)
+	if err := os.WriteFile(dir+"/mirror.m4s", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr := newTestServer(t, &Config{RootDir: dir})
+	_, mirror := newTestServer(t, &Config{RootDir: dir})
+
+	client, err := NewClient(&Config{Addr: addr, Mirrors: []string{mirror}, Digest: true, Seed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	recv, _, _, err := client.Get(ctx, "mirror.m4s", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, recv) {
+		t.Fatal("File from both servers does not match")
+	}
+
+	sources := client.Sources()
+	if len(sources) != 2 {
+		t.Fatalf("Expected 2 sources, got %v", sources)
+	}
+	for _, src := range sources {
+		if src.Innovative == 0 || src.Innovative > src.Pieces {
+			t.Errorf("Source %v contributed %v innovative of %v pieces", src.Addr, src.Innovative, src.Pieces)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	done := []bool{true, false, false, true, true, false, false, false, true}
+	pkt, err := EncodeResume(XNC_RESUME{Type: TYPE_RESUME, FileSize: 100000, ChunkNum: len(done), Done: done})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, err := ResumeSize(pkt[:RESUMEHEADERSIZE]); err != nil || size != len(pkt) {
+		t.Fatalf("Expected a resume frame of %v bytes, got %v, %v", len(pkt), size, err)
+	}
+	resume, err := DecodeResume(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resume.FileSize != 100000 || !reflect.DeepEqual(resume.Done, done) {
+		t.Fatalf("Resume frame does not match, got %+v", resume)
+	}
+	pkt, err = EncodeResume(XNC_RESUME{Type: TYPE_RESUME, FileSize: 5 << 30, ChunkNum: len(done), Done: done})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resume, err := DecodeResume(pkt); err != nil || resume.FileSize != 5<<30 {
+		t.Fatalf("Expected a resume of 5 GiB, got %+v, %v", resume, err)
+	}
+
+	dir := t.TempDir()
+	data := make([]byte, 64*4096)
+	rand.Read(data)
+	mem := NewMemSource()
+	mem.Put("resume.m4s", data)
+	mem.Put("drop.m4s", data)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr := newTestServer(t, &Config{Files: mem})
+
+	conf := &Config{Addr: addr, ChunkSize: 4096, PieceCount: 16, Digest: true, ResumeDir: dir}
+
+	// chunks left by an earlier client are only checked, not sent again
+	version := func(name string) int64 {
+		file, err := mem.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, _ := file.Stat()
+		return info.ModTime().UnixNano()
+	}
+	state := newTransferState(dir, resumeKey(conf, "resume.m4s", 0, 0))
+	state.reset(len(data), 64, version("resume.m4s"))
+	for id := 0; id < 48; id++ {
+		state.add(id, data[id*4096:(id+1)*4096])
+	}
+
+	client, err := NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	recv, _, _, err := client.Get(ctx, "resume.m4s", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, recv) {
+		t.Fatal("Resumed file does not match")
+	}
+	if pieces := client.Sources()[0].Pieces; pieces >= 32*conf.PieceCount {
+		t.Errorf("Expected the 16 missing chunks only, got %v pieces", pieces)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Expected the resume state to be removed, found %v", files)
+	}
+
+	// the session drops once a few chunks are decoded
+	state = client.transferState(resumeKey(client.conf, "drop.m4s", 0, 0))
+	dropped := make(chan struct{})
+	go func() {
+		for state.count() < 8 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+		if conn, err := client.Conn(ctx); err == nil {
+			conn.Close()
+		}
+		close(dropped)
+	}()
+
+	recv, _, _, err = client.GetSystematic(ctx, "drop.m4s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, recv) {
+		t.Fatal("File resumed after the session dropped does not match")
+	}
+	select {
+	case <-dropped:
+	default:
+		t.Error("Expected the session to drop during the transfer")
+	}
+
+	// a streamed request hands every byte over once across the drop
+	mem.Put("stream.m4s", data)
+	state = client.transferState(resumeKey(client.conf, "stream.m4s", 0, 0))
+	dropped = make(chan struct{})
+	go func() {
+		for state.count() < 8 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+		if conn, err := client.Conn(ctx); err == nil {
+			conn.Close()
+		}
+		close(dropped)
+	}()
+
+	var streamed []byte
+	recv, _, _, err = client.StreamRange(ctx, "stream.m4s", 0, 0, true, func(data []byte) {
+		streamed = append(streamed, data...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, recv) || !bytes.Equal(data, streamed) {
+		t.Fatalf("Streamed file does not match, %v bytes delivered", len(streamed))
+	}
+	select {
+	case <-dropped:
+	default:
+		t.Error("Expected the session to drop during the streamed transfer")
+	}
+
+	// chunks of a file replaced by one of the same size are sent again
+	mem.Put("stale.m4s", data)
+	state = newTransferState(dir, resumeKey(conf, "stale.m4s", 0, 0))
+	state.reset(len(data), 64, version("stale.m4s"))
+	for id := 0; id < 48; id++ {
+		state.add(id, data[id*4096:(id+1)*4096])
+	}
+	replaced := make([]byte, len(data))
+	rand.Read(replaced)
+	mem.Put("stale.m4s", replaced)
+
+	recv, _, _, err = client.Get(ctx, "stale.m4s", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(replaced, recv) {
+		t.Error("Expected the replaced file, not the chunks saved of the old one")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	data := make([]byte, 20*4096+100)
+	rand.Read(data)
+	mem := NewMemSource()
+	mem.Put("metrics.m4s", data)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, addr := newTestServer(t, &Config{Files: mem, CacheBytes: 1 << 20})
+	metrics := httptest.NewServer(server.MetricsHandler())
+	defer metrics.Close()
+
+	client, err := NewClient(&Config{Addr: addr, ChunkSize: 4096, PieceCount: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, _, _, err := client.Get(ctx, "metrics.m4s", true); err != nil {
+		t.Fatal(err)
+	}
+
+	transfers := client.Transfers()
+	if len(transfers) != 1 {
+		t.Fatalf("Expected 1 transfer, got %v", len(transfers))
+	}
+	stats := transfers[0]
+	if stats.Filename != "metrics.m4s" || stats.Bytes != len(data) || stats.Innovative != 21*16 || stats.Pieces < stats.Innovative+stats.Dependent {
+		t.Errorf("Transfer stats don't add up: %+v", stats)
+	}
+	if len(stats.ChunkLatency) != 21 || stats.Goodput() <= 0 || stats.Throughput() < stats.Goodput() || stats.Redundancy() < 0 {
+		t.Errorf("Expected a latency per chunk and goodput below throughput, got %+v", stats)
+	}
+	for id, latency := range stats.ChunkLatency {
+		if latency <= 0 {
+			t.Errorf("Chunk %v has no decode latency", id)
+		}
+	}
+
+	// the server hears about the last chunk after the client is done
+	deadline := time.Now().Add(2 * time.Second)
+	for server.Stats().ChunksDecoded < 21 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	served := server.Stats()
+	if served.Requests != 1 || served.ChunksDecoded != 21 || served.Pieces < int64(stats.Pieces) || served.Bytes < int64(stats.BytesReceived) {
+		t.Errorf("Server stats don't match the transfer: %+v", served)
+	}
+
+	resp, err := http.Get(metrics.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"xnc_requests_total 1",
+		fmt.Sprintf("xnc_pieces_sent_total %v", served.Pieces),
+		"xnc_chunk_decode_seconds_count 21",
+		"xnc_chunk_decode_seconds_bucket{le=\"+Inf\"} 21",
+		"xnc_cache_misses_total 21",
+	} {
+		if !bytes.Contains(body, []byte(line+"\n")) {
+			t.Errorf("Expected %q in metrics, got\n%s", line, body)
+		}
+	}
+}
+
+func TestRepair(t *testing.T) {
+	data := make([]byte, 64*4096)
+	rand.Read(data)
+	mem := NewMemSource()
+	mem.Put("repair.m4s", data)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr := newTestServer(t, &Config{Files: mem})
+
+	// nothing is lost, pieces still waiting in the datagram queue must not
+	// be taken for lost and repaired
+	modes := map[string]*Config{
+		"coded":    {},
+		"datagram": {Datagram: true},
+		"seeded":   {Datagram: true, Seed: true, PieceCount: 64, ChunkSize: 64 * 64},
+	}
+	for mode, modeConf := range modes {
+		conf := *modeConf
+		conf.Addr = addr
+		client, err := NewClient(&conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recv, _, _, err := client.Get(ctx, "repair.m4s", true)
+		client.Close()
+		if err != nil {
+			t.Fatalf("%v: %v", mode, err)
+		}
+		if !bytes.Equal(data, recv) {
+			t.Fatalf("%v: file does not match", mode)
+		}
+
+		stats := client.Transfers()[0]
+		if redundancy := stats.Redundancy(); redundancy > 0.1 {
+			t.Errorf("%v: expected at most 10%% redundancy without loss, got %.3f (%v pieces, %v innovative)", mode, redundancy, stats.Pieces, stats.Innovative)
+		}
+	}
+}
+
+// lossModel decides which packets of one direction a proxy drops
+type lossModel interface {
+	drop() bool
+}
+
+// randomLoss drops every packet with the same probability
+type randomLoss struct {
+	rate float64
+	rng  *rand.Rand
+}
+
+func (l *randomLoss) drop() bool {
+	return l.rng.Float64() < l.rate
+}
+
+// gilbertElliott drops packets in bursts: the channel moves from the good
+// to the bad state with probability p and back with probability r, and
+// loses packets with goodLoss and badLoss in them
+type gilbertElliott struct {
+	p, r              float64
+	goodLoss, badLoss float64
+	bad               bool
+	rng               *rand.Rand
+}
+
+func (l *gilbertElliott) drop() bool {
+	if l.bad {
+		l.bad = l.rng.Float64() >= l.r
+	} else {
+		l.bad = l.rng.Float64() < l.p
+	}
+
+	if l.bad {
+		return l.rng.Float64() < l.badLoss
+	}
+	return l.rng.Float64() < l.goodLoss
+}
+
+// periodicLoss drops burst packets in a row every period packets
+type periodicLoss struct {
+	period, burst int
+	count         int
+}
+
+func (l *periodicLoss) drop() bool {
+	l.count++
+	return l.count%l.period < l.burst
+}
+
+// dropCallback applies a model of newModel to each direction. The proxy
+// calls it from one goroutine per direction, the models aren't shared.
+// The first packets carry the handshake and are never dropped.
+func dropCallback(newModel func(seed int64) lossModel) quicproxy.DropCallback {
+	models := []lossModel{newModel(1), newModel(2)}
+
+	return func(dir quicproxy.Direction, count uint64) bool {
+		if count <= 10 {
+			return false
+		}
+		return models[dir].drop()
+	}
+}
+
+func TestLoss(t *testing.T) {
+	// most redundancy a transfer through the lossy proxy may see
+	const maxRedundancy = 0.5
+
+	data := make([]byte, 40*4096+1234)
+	rand.Read(data)
+	mem := NewMemSource()
+	mem.Put("loss.m4s", data)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr := newTestServer(t, &Config{Files: mem})
+
+	models := map[string]func(seed int64) lossModel{
+		"random 10%": func(seed int64) lossModel {
+			return &randomLoss{rate: 0.1, rng: rand.New(rand.NewSource(seed))}
+		},
+		"gilbert-elliott": func(seed int64) lossModel {
+			// 1 in 5 packets lost on average, in bursts of 5
+			return &gilbertElliott{p: 0.05, r: 0.2, badLoss: 1, rng: rand.New(rand.NewSource(seed))}
+		},
+		"periodic": func(seed int64) lossModel {
+			return &periodicLoss{period: 10, burst: 2}
+		},
+	}
+	modes := map[string]*Config{
+		"coded":      {},
+		"datagram":   {Datagram: true},
+		"systematic": {Datagram: true},
+		"seeded":     {Datagram: true, Seed: true, PieceCount: 64, ChunkSize: 64 * 64},
+	}
+
+	// every transfer gets its own proxy, its handshake is never dropped
+	for name, newModel := range models {
+		for mode, modeConf := range modes {
+			proxy, err := quicproxy.NewQuicProxy("localhost:0", quic.VersionNumber(0), &quicproxy.Opts{
+				RemoteAddr: addr,
+				DropPacket: dropCallback(newModel),
+				DelayPacket: func(quicproxy.Direction, uint64) time.Duration {
+					return 5 * time.Millisecond
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			conf := *modeConf
+			conf.Addr = proxy.LocalAddr().String()
+			conf.Digest = true
+			client, err := NewClient(&conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var recv []byte
+			if mode == "systematic" {
+				recv, _, _, err = client.GetSystematic(ctx, "loss.m4s")
+			} else {
+				recv, _, _, err = client.Get(ctx, "loss.m4s", true)
+			}
+			client.Close()
+			proxy.Close()
+			if err != nil {
+				t.Errorf("%v loss, %v: %v", name, mode, err)
+				continue
+			}
+			if !bytes.Equal(data, recv) {
+				t.Errorf("%v loss, %v: file does not match", name, mode)
+				continue
+			}
+
+			// pieces received beyond the innovative ones, what the server
+			// sent on top of the loss is never seen here
+			stats := client.Transfers()[0]
+			t.Logf("%v loss, %v: %v pieces, %v innovative, redundancy %.3f", name, mode, stats.Pieces, stats.Innovative, stats.Redundancy())
+			if stats.Redundancy() > maxRedundancy {
+				t.Errorf("%v loss, %v: expected at most %.2f redundancy, got %.3f", name, mode, maxRedundancy, stats.Redundancy())
+			}
+		}
+	}
+}
+
+func TestError(t *testing.T) {
+	cause := fmt.Errorf("piece size is not correct")
+	err := error(newError(ErrDecode, cause))
+
+	if !errors.Is(err, ErrDecode) || errors.Is(err, ErrTimeout) {
+		t.Errorf("Error %v has the wrong kind", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("Error %v does not wrap its cause", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+	if err := ctxError(ctx); !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := ctxError(ctx); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestXNC(t *testing.T) {
+	xnc := XNC{
+		ChunkId:    1,
+		Type:       TYPE_XNC_ENC,
+		ChunkSize:  4,
+		ChunkNum:   10,
+		Seq:        7,
+		PieceCount: 32,
+		Vector:     make([]byte, 32),
+		Piece:      make([]byte, 100),
+	}
+
+	for i := range xnc.Vector {
+		xnc.Vector[i] = byte(i)
+	}
+	for i := range xnc.Piece {
+		xnc.Piece[i] = byte(i)
+	}
+
+	encode, err := EncodeXNCPkt(xnc)
+	if err != nil {
+		t.Errorf("Error encode xnc: %v", err)
+		return
+	}
+	decode, err := DecodeXNCPkt(encode)
+	if err != nil {
+		t.Errorf("Error decode xnc: %v", err)
+		return
+	}
+
+	if !XNCEqual(xnc, decode) {
+		t.Errorf("Failed to decode xnc correctly.\nExpected: %v\nGot: %v", xnc, decode)
+		return
+	}
+
+	t.Logf("## Successfully decoded XNC packets\n")
+}
+
+func XNCEqual(a, b XNC) bool {
+	if a.ChunkId != b.ChunkId {
+		return false
+	}
+	if a.Type != b.Type {
+		return false
+	}
+	if a.ChunkSize != b.ChunkSize {
+		return false
+	}
+
+	if a.ChunkNum != b.ChunkNum {
+		return false
+	}
+	if a.Seq != b.Seq {
+		return false
+	}
+	if a.PieceCount != b.PieceCount {
 		return false
 	}
 	if !bytes.Equal(a.Piece, b.Piece) || !bytes.Equal(a.Vector, b.Vector) {